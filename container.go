@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// defaultContainerAnnotation is the annotation used by kubectl exec and kubectl
+// logs to select the container of a pod when none is given on the command line.
+const defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+// podContainers returns the containers of the pod that may serve requests: the
+// regular containers, followed by native sidecars, which are init containers
+// with a restartPolicy of Always that keep running alongside the pod.
+func podContainers(pod *corev1.Pod) []corev1.Container {
+	containers := make([]corev1.Container, 0, len(pod.Spec.Containers)+len(pod.Spec.InitContainers))
+	containers = append(containers, pod.Spec.Containers...)
+	for _, container := range pod.Spec.InitContainers {
+		if container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+			containers = append(containers, container)
+		}
+	}
+	return containers
+}
+
+// findContainer returns the container of the pod with the given name.
+func findContainer(pod *corev1.Pod, containerName string) (*corev1.Container, bool) {
+	containers := podContainers(pod)
+	for i := range containers {
+		if containers[i].Name == containerName {
+			return &containers[i], true
+		}
+	}
+	return nil, false
+}
+
+func selectContainerPort(pod *corev1.Pod, containerName, portName string) (selectedContainerName string, selectedContainerPort corev1.ContainerPort, err error) {
+	if containerName != "" {
+		if _, ok := findContainer(pod, containerName); !ok {
+			err = fmt.Errorf("container %s not found in pod %s", containerName, pod.Name)
+			return
+		}
+	}
+
+	var matches []string
+	for _, container := range podContainers(pod) {
+		if containerName != "" && container.Name != containerName {
+			continue
+		}
+		for _, port := range container.Ports {
+			if port.Name != portName || port.Protocol != corev1.ProtocolTCP {
+				continue
+			}
+			// When multiple containers expose the port, the default
+			// container of the pod wins.
+			if selectedContainerPort.Name == "" || container.Name == pod.Annotations[defaultContainerAnnotation] {
+				selectedContainerName = container.Name
+				selectedContainerPort = port
+			}
+			matches = append(matches, container.Name)
+		}
+	}
+
+	switch {
+	case len(matches) == 0:
+		err = fmt.Errorf("pod %s had no containers exposing a %s port", pod.Name, portName)
+	case len(matches) > 1 && selectedContainerName != pod.Annotations[defaultContainerAnnotation]:
+		err = fmt.Errorf("pod %s has multiple containers with a %s port (%s), use -c/--container to specify which one to send the request to",
+			pod.Name, portName, strings.Join(matches, ", "))
+	}
+	return
+}
+
+// wellKnownPorts are the ports used when nothing in the pod indicates which
+// port serves requests of a scheme.
+var wellKnownPorts = map[string]int32{
+	"http":  80,
+	"https": 443,
+}
+
+// inferContainerPort selects the port to send requests to when none was given
+// in the URL. The first of these rules which matches selects the port:
+//
+//   - the TCP port named after the URL scheme
+//   - the only TCP port declared by the containers
+//   - the port of an httpGet readiness or liveness probe
+//   - the prometheus.io/port annotation of the pod
+//   - the well-known port of the URL scheme
+//
+// Only the first rule looks at every container of the pod when no container
+// was given, the others are limited to the container of the
+// kubectl.kubernetes.io/default-container annotation if the pod has one.
+//
+// The returned rule describes which of these selected the port.
+func inferContainerPort(pod *corev1.Pod, containerName, scheme string) (selectedContainerName string, port int32, rule string, err error) {
+	if containerName != "" {
+		if _, ok := findContainer(pod, containerName); !ok {
+			return "", 0, "", fmt.Errorf("container %s not found in pod %s", containerName, pod.Name)
+		}
+	}
+
+	var containers []corev1.Container
+	for _, container := range podContainers(pod) {
+		if containerName == "" || container.Name == containerName {
+			containers = append(containers, container)
+		}
+	}
+
+	for _, container := range containers {
+		for _, p := range container.Ports {
+			if p.Name == scheme && p.Protocol == corev1.ProtocolTCP {
+				name, namedPort, err := selectContainerPort(pod, containerName, scheme)
+				if err != nil {
+					return "", 0, "", err
+				}
+				return name, namedPort.ContainerPort, fmt.Sprintf("port named %s", scheme), nil
+			}
+		}
+	}
+
+	// Without -c/--container, the remaining rules only look at the default
+	// container of the pod when it names one, like kubectl exec does.
+	if containerName == "" {
+		if name := pod.Annotations[defaultContainerAnnotation]; name != "" {
+			if container, ok := findContainer(pod, name); ok {
+				containerName, containers = name, []corev1.Container{*container}
+			}
+		}
+	}
+
+	var tcpPorts []int32
+	for _, container := range containers {
+		for _, p := range container.Ports {
+			if p.Protocol == corev1.ProtocolTCP {
+				selectedContainerName, port = container.Name, p.ContainerPort
+				tcpPorts = append(tcpPorts, p.ContainerPort)
+			}
+		}
+	}
+	if len(tcpPorts) == 1 {
+		return selectedContainerName, port, "only declared TCP port", nil
+	}
+
+	for _, probeType := range []string{"readiness", "liveness"} {
+		for _, container := range containers {
+			probe := container.ReadinessProbe
+			if probeType == "liveness" {
+				probe = container.LivenessProbe
+			}
+			if probe == nil || probe.HTTPGet == nil {
+				continue
+			}
+			if p, ok := resolveContainerPort(&container, probe.HTTPGet.Port); ok {
+				return container.Name, p, fmt.Sprintf("port of the %s probe", probeType), nil
+			}
+		}
+	}
+
+	if value, ok := pod.Annotations["prometheus.io/port"]; ok {
+		if p, err := strconv.ParseUint(value, 10, 16); err == nil && p != 0 {
+			return containerName, int32(p), "prometheus.io/port annotation", nil
+		}
+	}
+
+	if p, ok := wellKnownPorts[scheme]; ok {
+		return containerName, p, fmt.Sprintf("well-known %s port", scheme), nil
+	}
+
+	return "", 0, "", fmt.Errorf("unable to infer the port to send the request to in pod %s, specify it in the URL", pod.Name)
+}
+
+// resolveContainerPort returns the port number that port refers to in the
+// container, resolving port names to the container ports they declare.
+func resolveContainerPort(container *corev1.Container, port intstr.IntOrString) (int32, bool) {
+	if port.Type == intstr.Int {
+		return port.IntVal, port.IntVal != 0
+	}
+	for _, p := range container.Ports {
+		if p.Name == port.StrVal {
+			return p.ContainerPort, true
+		}
+	}
+	if n, err := strconv.ParseUint(port.StrVal, 10, 16); err == nil && n != 0 {
+		return int32(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestInferContainerPort(t *testing.T) {
+	httpGet := func(port intstr.IntOrString) *corev1.Probe {
+		return &corev1.Probe{ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Port: port}}}
+	}
+	defaultContainer := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Annotations: map[string]string{defaultContainerAnnotation: name}}
+	}
+	always := corev1.ContainerRestartPolicyAlways
+	tcp := func(name string, port int32) []corev1.ContainerPort {
+		return []corev1.ContainerPort{{Name: name, ContainerPort: port, Protocol: corev1.ProtocolTCP}}
+	}
+
+	tests := []struct {
+		scenario      string
+		pod           corev1.Pod
+		containerName string
+		scheme        string
+		container     string
+		port          int32
+		rule          string
+	}{
+		{
+			scenario: "port named after the scheme",
+			pod: corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:  "app",
+				Ports: []corev1.ContainerPort{{Name: "grpc", ContainerPort: 9000, Protocol: corev1.ProtocolTCP}, {Name: "http", ContainerPort: 8080, Protocol: corev1.ProtocolTCP}},
+			}}}},
+			scheme: "http",
+			port:   8080,
+			rule:   "port named http",
+		},
+		{
+			scenario: "only declared TCP port",
+			pod: corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:  "app",
+				Ports: []corev1.ContainerPort{{Name: "web", ContainerPort: 3000, Protocol: corev1.ProtocolTCP}, {Name: "dns", ContainerPort: 53, Protocol: corev1.ProtocolUDP}},
+			}}}},
+			scheme: "http",
+			port:   3000,
+			rule:   "only declared TCP port",
+		},
+		{
+			scenario: "named port of the readiness probe",
+			pod: corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:           "app",
+				Ports:          []corev1.ContainerPort{{Name: "web", ContainerPort: 3000, Protocol: corev1.ProtocolTCP}, {Name: "admin", ContainerPort: 3001, Protocol: corev1.ProtocolTCP}},
+				ReadinessProbe: httpGet(intstr.FromString("admin")),
+				LivenessProbe:  httpGet(intstr.FromInt(3000)),
+			}}}},
+			scheme: "http",
+			port:   3001,
+			rule:   "port of the readiness probe",
+		},
+		{
+			scenario: "port of the liveness probe",
+			pod: corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:          "app",
+				LivenessProbe: httpGet(intstr.FromInt(8081)),
+			}}}},
+			scheme: "http",
+			port:   8081,
+			rule:   "port of the liveness probe",
+		},
+		{
+			scenario: "prometheus annotation",
+			pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"prometheus.io/port": "9102"}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			},
+			scheme: "http",
+			port:   9102,
+			rule:   "prometheus.io/port annotation",
+		},
+		{
+			scenario: "well-known port",
+			pod:      corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}},
+			scheme:   "https",
+			port:     443,
+			rule:     "well-known https port",
+		},
+		{
+			scenario: "port of the selected container",
+			pod: corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "app", Ports: []corev1.ContainerPort{{ContainerPort: 8080, Protocol: corev1.ProtocolTCP}}},
+				{Name: "proxy", Ports: []corev1.ContainerPort{{ContainerPort: 15000, Protocol: corev1.ProtocolTCP}}},
+			}}},
+			containerName: "proxy",
+			scheme:        "http",
+			container:     "proxy",
+			port:          15000,
+			rule:          "only declared TCP port",
+		},
+		{
+			scenario: "named port of the selected container",
+			pod: corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "app", Ports: tcp("http", 8080)},
+				{Name: "proxy", Ports: tcp("http", 15000)},
+			}}},
+			containerName: "proxy",
+			scheme:        "http",
+			container:     "proxy",
+			port:          15000,
+			rule:          "port named http",
+		},
+		{
+			scenario: "named port of the default container",
+			pod: corev1.Pod{ObjectMeta: defaultContainer("app"), Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "proxy", Ports: tcp("http", 15000)},
+				{Name: "app", Ports: tcp("http", 8080)},
+			}}},
+			scheme:    "http",
+			container: "app",
+			port:      8080,
+			rule:      "port named http",
+		},
+		{
+			scenario: "named port of another container than the default one",
+			pod: corev1.Pod{ObjectMeta: defaultContainer("app"), Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "app", Ports: tcp("web", 8080)},
+				{Name: "proxy", Ports: tcp("http", 15000)},
+			}}},
+			scheme:    "http",
+			container: "proxy",
+			port:      15000,
+			rule:      "port named http",
+		},
+		{
+			scenario: "only TCP port of the default container",
+			pod: corev1.Pod{ObjectMeta: defaultContainer("app"), Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "app", Ports: tcp("web", 8080)},
+				{Name: "proxy", Ports: tcp("admin", 15000)},
+			}}},
+			scheme:    "http",
+			container: "app",
+			port:      8080,
+			rule:      "only declared TCP port",
+		},
+		{
+			scenario: "probe port of the default container",
+			pod: corev1.Pod{ObjectMeta: defaultContainer("app"), Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "proxy", Ports: tcp("admin", 15000), ReadinessProbe: httpGet(intstr.FromInt(15021))},
+				{Name: "app", LivenessProbe: httpGet(intstr.FromInt(8081))},
+			}}},
+			scheme:    "http",
+			container: "app",
+			port:      8081,
+			rule:      "port of the liveness probe",
+		},
+		{
+			scenario: "default container which does not exist",
+			pod: corev1.Pod{ObjectMeta: defaultContainer("gone"), Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "app", Ports: tcp("web", 8080)},
+			}}},
+			scheme:    "http",
+			container: "app",
+			port:      8080,
+			rule:      "only declared TCP port",
+		},
+		{
+			scenario: "port of a native sidecar",
+			pod: corev1.Pod{Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{
+					{Name: "init", Ports: tcp("http", 9000)},
+					{Name: "proxy", RestartPolicy: &always, Ports: tcp("http", 15000)},
+				},
+				Containers: []corev1.Container{{Name: "app", Ports: tcp("web", 8080)}},
+			}},
+			scheme:    "http",
+			container: "proxy",
+			port:      15000,
+			rule:      "port named http",
+		},
+		{
+			scenario: "native sidecar selected with -c",
+			pod: corev1.Pod{Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "proxy", RestartPolicy: &always, Ports: tcp("admin", 15000)}},
+				Containers:     []corev1.Container{{Name: "app", Ports: tcp("web", 8080)}},
+			}},
+			containerName: "proxy",
+			scheme:        "http",
+			container:     "proxy",
+			port:          15000,
+			rule:          "only declared TCP port",
+		},
+		{
+			scenario: "native sidecar as the default container",
+			pod: corev1.Pod{ObjectMeta: defaultContainer("proxy"), Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "proxy", RestartPolicy: &always, ReadinessProbe: httpGet(intstr.FromInt(15021))}},
+				Containers:     []corev1.Container{{Name: "app", Ports: tcp("web", 8080)}},
+			}},
+			scheme:    "http",
+			container: "proxy",
+			port:      15021,
+			rule:      "port of the readiness probe",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			container, port, rule, err := inferContainerPort(&test.pod, test.containerName, test.scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.container != "" && container != test.container {
+				t.Errorf("container mismatch: want=%q got=%q", test.container, container)
+			}
+			if port != test.port {
+				t.Errorf("port mismatch: want=%d got=%d", test.port, port)
+			}
+			if rule != test.rule {
+				t.Errorf("rule mismatch: want=%q got=%q", test.rule, rule)
+			}
+		})
+	}
+}
+
+func TestInferContainerPortErrors(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "a", Ports: []corev1.ContainerPort{{ContainerPort: 8080, Protocol: corev1.ProtocolTCP}}},
+			{Name: "b", Ports: []corev1.ContainerPort{{ContainerPort: 9090, Protocol: corev1.ProtocolTCP}}},
+		}},
+	}
+
+	if _, _, _, err := inferContainerPort(pod, "", "ftp"); err == nil {
+		t.Error("expected an error when no rule selects a port")
+	}
+	if _, _, _, err := inferContainerPort(pod, "c", "http"); err == nil {
+		t.Error("expected an error for a container which does not exist")
+	}
+
+	pod.Spec.Containers[0].Ports[0].Name = "http"
+	pod.Spec.Containers[1].Ports[0].Name = "http"
+	if _, _, _, err := inferContainerPort(pod, "", "http"); err == nil {
+		t.Error("expected an error when multiple containers have a port named after the scheme")
+	}
+	pod.Annotations = map[string]string{defaultContainerAnnotation: "b"}
+	if name, port, _, err := inferContainerPort(pod, "", "http"); err != nil || name != "b" || port != 9090 {
+		t.Errorf("the default container should break the tie: container=%q port=%d err=%v", name, port, err)
+	}
+}
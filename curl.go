@@ -1,16 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"log"
 	"math/rand"
 	"net"
-	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"os/signal"
 	"strconv"
 	"strings"
@@ -26,7 +25,6 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/portforward"
-	"k8s.io/client-go/transport/spdy"
 
 	"github.com/segmentio/kubectl-curl/curl"
 )
@@ -34,12 +32,35 @@ import (
 var (
 	curlOptions = curl.NewOptionSet()
 
-	help    bool
-	debug   bool
-	options string
-	flags   *pflag.FlagSet
-	cflags  *pflag.FlagSet
-	config  *genericclioptions.ConfigFlags
+	help           bool
+	debug          bool
+	allPods        bool
+	concurrency    int
+	pick           string
+	includeUnready bool
+	labelSelector  string
+	fieldSelector  string
+	nodeName       string
+	container      string
+	probe          string
+	cacertFrom     string
+	certFrom       string
+	serviceAccount string
+	audience       string
+	tokenTTL       time.Duration
+	headersFrom    []string
+	userinfoFrom   string
+	executor       string
+	localPort      int
+	addresses      []string
+	transport      string
+	via            string
+	debugImage     string
+	from           string
+	options        string
+	flags          *pflag.FlagSet
+	cflags         *pflag.FlagSet
+	config         *genericclioptions.ConfigFlags
 )
 
 func init() {
@@ -53,22 +74,73 @@ func init() {
 	flags.BoolVarP(&help, "help", "h", false, "Prints the kubectl plugin help.")
 	flags.BoolVarP(&debug, "debug", "", false,
 		"Enable debug mode to print more details about the kubectl command execution.")
+	flags.BoolVarP(&allPods, "all-pods", "", false,
+		"Send the request to every pod of the resource instead of a single one.")
+	flags.IntVarP(&concurrency, "concurrency", "", 4,
+		"Maximum number of pods that requests are sent to concurrently with --all-pods.")
+	flags.StringVarP(&pick, "pick", "", "ready",
+		"Strategy used to pick the pod of a resource: ready, random, newest, oldest, index=N, or node=NAME.")
+	flags.BoolVarP(&includeUnready, "include-unready", "", false,
+		"Allow picking pods of a resource which are not ready or are terminating.")
+	flags.StringVarP(&labelSelector, "selector", "l", "",
+		"Label selector of the pods to send the request to, the URL host must then be omitted or set to _.")
+	flags.StringVarP(&fieldSelector, "field-selector", "", "",
+		"Field selector of the pods to send the request to, the URL host must then be omitted or set to _.")
+	flags.StringVarP(&nodeName, "node", "", "",
+		"Send the request to the pod of the resource scheduled on this node, same as TYPE/NAME@NODE in the URL.")
+	flags.StringVarP(&container, "container", "c", "",
+		"Container to send the request to. If omitted, use the kubectl.kubernetes.io/default-container annotation, or the only container exposing the port.")
+	flags.StringVarP(&probe, "probe", "", "",
+		"Replay the readiness, liveness, or startup httpGet probe of the container instead of sending a request to a URL.")
+	flags.StringVarP(&cacertFrom, "cacert-from", "", "",
+		"Verify the server with the CA certificate of secret/NAME[:KEY], configmap/NAME[:KEY], or certificate/NAME[:KEY] (cert-manager), the key defaults to ca.crt. "+
+			"Defaults to the kube-root-ca.crt ConfigMap of the namespace for https URLs.")
+	flags.StringVarP(&certFrom, "cert-from", "", "",
+		"Authenticate with the client certificate of the kubernetes.io/tls secret/NAME, using its tls.crt, tls.key, and ca.crt keys.")
+	flags.StringVarP(&serviceAccount, "as-serviceaccount", "", "",
+		"Send a bearer token of this service account, minted with the TokenRequest API.")
+	flags.StringVarP(&audience, "audience", "", "",
+		"Audience of the --as-serviceaccount token, defaults to the audience of the API server.")
+	flags.DurationVarP(&tokenTTL, "token-ttl", "", minTokenTTL,
+		"Lifetime of the --as-serviceaccount token.")
+	flags.StringArrayVarP(&headersFrom, "header-from", "", nil,
+		"Send a header with the value of a secret or ConfigMap key, in the form secret/NAME:KEY=HEADER-NAME. Can be repeated.")
+	flags.StringVarP(&userinfoFrom, "userinfo-from", "", "",
+		"Authenticate with the username and password keys of secret/NAME, like --userinfo.")
+	flags.StringVarP(&executor, "executor", "", "auto",
+		"How requests are sent: curl (the curl binary), native (the built-in HTTP client), or auto (native unless an option requires the curl binary).")
+	flags.IntVarP(&localPort, "local-port", "", 0,
+		"Local port that the pod port is forwarded to, requests are then sent with the curl binary. A free port is used by default.")
+	flags.StringSliceVarP(&addresses, "address", "", []string{"localhost"},
+		"Local addresses that the pod port is forwarded to, requests are then sent with the curl binary.")
+	flags.StringVarP(&transport, "transport", "", curl.TransportAuto,
+		"Transport of the port forwarding connection to the API server: websocket, spdy, or auto (websocket, falling back to spdy).")
+	flags.StringVarP(&via, "via", "", "portforward",
+		"How requests reach the pod: portforward (a port forwarded to the pod), apiserver (the proxy subresource of the pod or service), "+
+			"exec (curl ran in the container, which reaches ports listening on 127.0.0.1), or debug (curl ran in an ephemeral container of the pod).")
+	flags.StringVarP(&debugImage, "debug-image", "", defaultDebugImage,
+		"Image of the ephemeral container that requests are sent from with --via debug, it must provide curl.")
+	flags.StringVarP(&from, "from", "", "",
+		"Send the request from this pod or resource (e.g. deploy/frontend) to the in-cluster address of the target, with --via exec (the default) or --via debug.")
 	cflags = pflag.NewFlagSet("curl", pflag.ExitOnError) // curl-only FlagSet
 	for _, opt := range curlOptions {
 		name := strings.TrimPrefix(opt.Name, "--")
 		short := strings.TrimPrefix(opt.Short, "-")
 
-		// Rewrite option names that conflict with the kubectl default options:
-		switch name {
-		case "user": // * Change curl's "--user" option to "--userinfo"
-			name = "userinfo"
+		// Rewrite option names that conflict with the kubectl options:
+		// * Change curl's "--user" option to "--userinfo"
+		// * Change curl's "--local-port" option to "--curl-local-port"
+		if renamed, ok := renamedOptions[name]; ok {
+			name = renamed
 		}
 
 		switch short {
-		case "n", "s":
+		case "n", "s", "l", "c":
 			// Remove short names that conflict with the kubectl default options:
 			// * "-n" conflicts between kubectl's "--namespace" and curl's "--netrc"
 			// * "-s" conflicts between kubectl's "--server" and curl's "--silent"
+			// * "-l" conflicts between kubectl's "--selector" and curl's "--list-only"
+			// * "-c" conflicts between kubectl's "--container" and curl's "--cookie-jar"
 			short = ""
 		}
 
@@ -106,9 +178,9 @@ func run(ctx context.Context) error {
 		found := cflags.Lookup(flag.Name)
 		if found != nil {
 			if flag.Value.Type() == "bool" {
-				cArgs = append(cArgs, "--"+flag.Name)
+				cArgs = append(cArgs, curlOptionName(flag.Name))
 			} else {
-				cArgs = append(cArgs, "--"+flag.Name)
+				cArgs = append(cArgs, curlOptionName(flag.Name))
 				cArgs = append(cArgs, value)
 			}
 		}
@@ -144,6 +216,82 @@ func run(ctx context.Context) error {
 		return usageError("too many arguments passed in the command line invocation of kubectl curl")
 	}
 
+	picker, err := parsePodPicker(pick)
+	if err != nil {
+		return usageError(err.Error())
+	}
+	switch executor {
+	case "auto", "curl", "native":
+	default:
+		return usageError(fmt.Sprintf("unsupported executor: %q (expected auto, curl, or native)", executor))
+	}
+	switch transport {
+	case curl.TransportAuto, curl.TransportWebSocket, curl.TransportSPDY:
+	default:
+		return usageError(fmt.Sprintf("unsupported transport: %q (expected auto, websocket, or spdy)", transport))
+	}
+	switch via {
+	case "portforward", "apiserver", "exec", "debug":
+	default:
+		return usageError(fmt.Sprintf("unsupported --via value: %q (expected portforward, apiserver, exec, or debug)", via))
+	}
+	if err := parseProbeType(probe); err != nil {
+		return usageError(err.Error())
+	}
+	if probe != "" && allPods {
+		return usageError("--probe cannot be combined with --all-pods")
+	}
+	if localPort != 0 && allPods {
+		return usageError("--local-port cannot be combined with --all-pods")
+	}
+	if localPort < 0 || localPort > 65535 {
+		return usageError(fmt.Sprintf("invalid local port: %d", localPort))
+	}
+	if len(addresses) == 0 {
+		return usageError("--address requires at least one address")
+	}
+	if from != "" {
+		if !flags.Changed("via") {
+			via = "exec"
+		}
+		if via != "exec" && via != "debug" {
+			return usageError("--from requires --via exec or --via debug")
+		}
+		if probe != "" {
+			return usageError("--probe cannot be combined with --from, probes are sent by the kubelet")
+		}
+	}
+	if via != "portforward" {
+		if localPort != 0 || flags.Changed("address") {
+			return usageError(fmt.Sprintf("--local-port and --address cannot be combined with --via %s", via))
+		}
+		// The API server opens the TLS connections to the pods without
+		// verifying their certificates, and curl in a container has no
+		// access to the local certificate files.
+		if cacertFrom != "" || certFrom != "" {
+			return usageError(fmt.Sprintf("--cacert-from and --cert-from cannot be combined with --via %s", via))
+		}
+	}
+	// The Authorization header of the requests is removed by the API server
+	// proxy, which would silently drop these credentials.
+	if via == "apiserver" && (serviceAccount != "" || userinfoFrom != "" || hasArg(cArgs, "--oauth2-bearer")) {
+		return usageError("--as-serviceaccount, --userinfo-from and --oauth2-bearer cannot be combined with --via apiserver, which authenticates requests with the Authorization header")
+	}
+	if localPort != 0 || flags.Changed("address") {
+		// Only the curl binary connects through a local port, the native
+		// executor dials the pod directly.
+		if executor == "native" {
+			return usageError("--local-port and --address cannot be combined with --executor native, which does not listen on a local port")
+		}
+		executor = "curl"
+	}
+	if (via == "exec" || via == "debug") && executor == "native" {
+		return usageError(fmt.Sprintf("--executor native cannot be combined with --via %s, which runs the curl binary of a container", via))
+	}
+	if flags.Changed("debug-image") && via != "debug" {
+		return usageError("--debug-image requires --via debug")
+	}
+
 	if strings.Index(query, "://") < 0 {
 		query = "http://" + query
 	}
@@ -154,97 +302,326 @@ func run(ctx context.Context) error {
 	}
 
 	// Initialize kube config and client before parsing host/port/resource
-	kubeConfig := config.ToRawKubeConfigLoader()
-	namespace, _, err := kubeConfig.Namespace()
+	resolver, restConfig, err := newPodResolver(config)
 	if err != nil {
 		return err
 	}
-	restConfig, err := config.ToRESTConfig()
+
+	target, err := curl.ParseResourceTarget(requestURL, resolver.lookupResourceType)
 	if err != nil {
 		return err
 	}
-	client, err := kubernetes.NewForConfig(restConfig)
+	if err := resolveTargetContext(&target); err != nil {
+		return err
+	}
+
+	if target.Context != "" {
+		// The URL selects a kubeconfig context, the client and the target
+		// must be reinitialized since resource types may differ between
+		// clusters.
+		if config.Context != nil && *config.Context != "" && *config.Context != target.Context {
+			return usageError(fmt.Sprintf("conflicting contexts in URL (%s) and --context (%s)", target.Context, *config.Context))
+		}
+		config.Context = &target.Context
+		resolver, restConfig, err = newPodResolver(config)
+		if err != nil {
+			return err
+		}
+		target, err = curl.ParseResourceTarget(requestURL, resolver.lookupResourceType)
+		if err != nil {
+			return err
+		}
+		if err := resolveTargetContext(&target); err != nil {
+			return err
+		}
+	}
+
+	// The source pod is resolved before the namespace of the target is
+	// selected, so the two default to the same namespace.
+	var sourcePod *corev1.Pod
+	if from != "" {
+		if sourcePod, err = resolveSourcePod(ctx, resolver, from, picker); err != nil {
+			return err
+		}
+		if debug || isVerbose(cArgs) {
+			_, _ = fmt.Fprintf(os.Stderr, "Sending the request from pod/%s in namespace %s\n", sourcePod.Name, sourcePod.Namespace)
+		}
+	}
+
+	if target.Namespace != "" {
+		if config.Namespace != nil && *config.Namespace != "" && *config.Namespace != target.Namespace {
+			return usageError(fmt.Sprintf("conflicting namespaces in URL (%s) and --namespace (%s)", target.Namespace, *config.Namespace))
+		}
+		resolver.namespace = target.Namespace
+	}
+
+	if target.PodIP != "" {
+		// Pod IPs are unique in the cluster, so the pod is searched in all
+		// namespaces unless one was explicitly given.
+		allNamespaces := target.Namespace == "" && (config.Namespace == nil || *config.Namespace == "")
+		pod, err := resolver.resolvePodFromIP(ctx, target.PodIP, allNamespaces)
+		if err != nil {
+			return err
+		}
+		if debug || isVerbose(cArgs) {
+			_, _ = fmt.Fprintf(os.Stderr, "Found pod/%s in namespace %s with IP %s\n", pod.Name, pod.Namespace, target.PodIP)
+		}
+		resolver.namespace = pod.Namespace
+		target.PodName = pod.Name
+	}
+
+	for _, name := range []string{target.ContainerName, container} {
+		if name != "" {
+			if containerName != "" && containerName != name {
+				return usageError(fmt.Sprintf("conflicting container names %s and %s", containerName, name))
+			}
+			containerName = name
+		}
+	}
+
+	client, namespace := resolver.client, resolver.namespace
+	isResource := target.IsResource
+	resourceType, resourceName := target.ResourceType, target.ResourceName
+	podName, podPort := target.PodName, target.PodPort
+	requestURL.Path = target.NewPath
+	if probe != "" && (podPort != "" || strings.Trim(requestURL.Path, "/") != "" || requestURL.RawQuery != "") {
+		return usageError("--probe sends the request of the probe, the target must not have a port or a path")
+	}
+	if debug {
+		_, _ = fmt.Fprintf(os.Stderr, "DEBUG: target=%+v\n", target)
+	}
+
+	isSelector, err := selectsPods(target, labelSelector, fieldSelector)
 	if err != nil {
 		return err
 	}
 
-	// Parse host and port, support <type>/<name>[:port] in host or host as type and first path segment as name
-	hostPort := requestURL.Host
-	var podName, podPort string
-	var resourceType, resourceName string
-	isResource := false
-	// Check if host is a resource type or abbreviation
-	if canonicalType, ok := resourceTypeMap[strings.ToLower(hostPort)]; ok && requestURL.Path != "" {
-		// host is resource type, first path segment is resource name (and maybe :port)
-		segments := strings.SplitN(strings.TrimLeft(requestURL.Path, "/"), "/", 2)
-		resourceAndMaybePort := segments[0]
-		resourceType = canonicalType
-		if colonIdx := strings.LastIndex(resourceAndMaybePort, ":"); colonIdx > -1 {
-			resourceName = resourceAndMaybePort[:colonIdx]
-			podPort = resourceAndMaybePort[colonIdx+1:]
-		} else {
-			resourceName = resourceAndMaybePort
+	if target.NodeName != "" {
+		if nodeName != "" && nodeName != target.NodeName {
+			return usageError(fmt.Sprintf("conflicting node names in URL (%s) and --node (%s)", target.NodeName, nodeName))
 		}
-		isResource = true
-		// Remove the resource segment from the path for the actual HTTP request
-		if len(segments) > 1 {
-			requestURL.Path = "/" + segments[1]
-		} else {
-			requestURL.Path = "/"
+		nodeName = target.NodeName
+	}
+	if !isResource && !isSelector && nodeName != "" {
+		return usageError("--node requires the URL to reference a resource, or pods to be selected with -l/--selector or --field-selector")
+	}
+
+	podNames := []string{podName}
+	var podPorts map[string]string
+	var serviceName string
+
+	if isResource && resolver.isServiceType(resourceType) && nodeName == "" && (via == "apiserver" || sourcePod != nil) && probe == "" && !allPods {
+		// The request is sent to the service by the API server, or by the
+		// source pod, which pick one of its endpoints.
+		serviceName = resourceName
+	} else if isResource || isSelector {
+		var pods []corev1.Pod
+		var source string
+
+		switch {
+		case isSelector:
+			source = selectorString(labelSelector, fieldSelector)
+			if debug || isVerbose(cArgs) {
+				_, _ = fmt.Fprintf(os.Stderr, "Resolving pods: %s, port=%s\n", source, podPort)
+			}
+			pods, err = resolver.resolvePodsFromSelector(ctx, labelSelector, fieldSelector)
+		case resolver.isServiceType(resourceType):
+			if nodeName != "" {
+				return fmt.Errorf("cannot target the pods of service %s by node, use the resource which manages them instead", resourceName)
+			}
+			source = "service/" + resourceName
+			if debug || isVerbose(cArgs) {
+				_, _ = fmt.Fprintf(os.Stderr, "Resolving service: name=%s, port=%s\n", resourceName, podPort)
+			}
+			// The endpoint pods are picked like the pods of other resources,
+			// and the request is sent to the target port of the service port
+			// on the pod, which may differ between pods.
+			var targetPorts map[string]int32
+			pods, targetPorts, err = resolver.resolvePodsFromService(ctx, resourceName, podPort, requestURL.Scheme)
+			if err == nil {
+				if debug || isVerbose(cArgs) {
+					_, _ = fmt.Fprintf(os.Stderr, "Found %d endpoints on target port %s\n", len(pods), targetPortsString(pods, targetPorts))
+				}
+				podPorts = make(map[string]string, len(targetPorts))
+				for name, port := range targetPorts {
+					podPorts[name] = strconv.Itoa(int(port))
+				}
+			}
+		default:
+			source = resourceType + "/" + resourceName
+			if debug || isVerbose(cArgs) {
+				_, _ = fmt.Fprintf(os.Stderr, "Resolving resource: type=%s, name=%s, port=%s\n", resourceType, resourceName, podPort)
+			}
+			pods, err = resolver.resolvePodsFromResource(ctx, resourceType, resourceName)
 		}
-		if debug {
-			_, _ = fmt.Fprintf(os.Stderr, "DEBUG: resourceType(from host)=%q, resourceName=%q, podPort=%q, newPath=%q\n", resourceType, resourceName, podPort, requestURL.Path)
-		}
-	} else if idx := strings.Index(hostPort, "/"); idx >= 0 {
-		// Looks like <type>/<name>[:port] in host
-		resourceAndMaybePort := hostPort
-		resource := resourceAndMaybePort
-		if colonIdx := strings.LastIndex(resourceAndMaybePort, ":"); colonIdx > -1 && colonIdx > idx {
-			resource = resourceAndMaybePort[:colonIdx]
-			podPort = resourceAndMaybePort[colonIdx+1:]
-		}
-		resourceParts := strings.SplitN(resource, "/", 2)
-		if len(resourceParts) == 2 {
-			resourceType, resourceName = resourceParts[0], resourceParts[1]
-			isResource = true
-			if debug {
-				_, _ = fmt.Fprintf(os.Stderr, "DEBUG: resourceType=%q, resourceName=%q, podPort=%q\n", resourceType, resourceName, podPort)
+		if err != nil {
+			return err
+		}
+
+		if nodeName != "" {
+			pods = podsOnNode(pods, nodeName)
+			if len(pods) == 0 {
+				return fmt.Errorf("no pods of %s are scheduled on node %s", source, nodeName)
 			}
-		} else {
-			return fmt.Errorf("invalid resource format: %s", resource)
+			source += "@" + nodeName
 		}
-	} else {
-		// podname[:port]
-		var err error
-		podName, podPort, err = net.SplitHostPort(hostPort)
+
+		eligible := eligiblePods(pods, includeUnready)
+		if len(eligible) == 0 {
+			return fmt.Errorf("none of the %d pods of %s are ready, use --include-unready to send the request anyway", len(pods), source)
+		}
+		pod, err := picker.pick(eligible)
 		if err != nil {
-			podName = hostPort
-			podPort = ""
+			return fmt.Errorf("unable to pick a pod of %s: %w", source, err)
+		}
+		if debug || isVerbose(cArgs) {
+			_, _ = fmt.Fprintf(os.Stderr, "Found %d pods (%d eligible), using pod/%s (pick=%s)\n", len(pods), len(eligible), pod.Name, picker)
+		}
+		podNames = make([]string, len(eligible))
+		for i, pod := range eligible {
+			podNames[i] = pod.Name
+		}
+		podName = pod.Name
+		if port, ok := podPorts[podName]; ok {
+			podPort = port
 		}
 		if debug {
-			_, _ = fmt.Fprintf(os.Stderr, "DEBUG: podName=%q, podPort=%q\n", podName, podPort)
+			_, _ = fmt.Fprintf(os.Stderr, "DEBUG: podName set to %q from %s\n", podName, source)
 		}
 	}
 
-	if isResource {
+	requestURL.Host = requestHost(requestURL, target, namespace, isResource && resolver.isServiceType(resourceType))
+
+	if userinfoFrom != "" && hasArg(cArgs, "--user") {
+		return usageError("--userinfo-from cannot be combined with --userinfo")
+	}
+	var token string
+	if serviceAccount != "" {
+		if hasArg(cArgs, "--oauth2-bearer") {
+			return usageError("--as-serviceaccount cannot be combined with --oauth2-bearer")
+		}
+		token, err = createServiceAccountToken(ctx, resolver, serviceAccount, audience, tokenTTL)
+		if err != nil {
+			return err
+		}
 		if debug || isVerbose(cArgs) {
-			_, _ = fmt.Fprintf(os.Stderr, "Resolving resource: type=%s, name=%s, port=%s\n", resourceType, resourceName, podPort)
+			_, _ = fmt.Fprintf(os.Stderr, "Using a token of serviceaccount %s valid for %s\n", serviceAccount, tokenTTL)
 		}
-		pods, resolvedPodName, err := resolvePodFromResource(ctx, client, namespace, resourceType, resourceName)
+	} else if flags.Changed("audience") || flags.Changed("token-ttl") {
+		return usageError("--audience and --token-ttl require --as-serviceaccount")
+	}
+	if hasConfigSecrets() && isVerbose(cArgs) && (executor == "curl" || via == "exec" || via == "debug") {
+		return usageError(verboseSecretsError)
+	}
+	// The options of curl in a container are sent on its standard input, so
+	// they stay out of its command line, unless the standard input is used
+	// by @- values; see newRemoteCurl.
+	if (via == "exec" || via == "debug") && readsStdin(cArgs) && (hasConfigSecrets() || hasSecretData(cArgs)) {
+		return usageError(fmt.Sprintf("--header-from, --userinfo-from, --as-serviceaccount and data from secrets cannot be combined with @- and --via %s, they would be passed in the command line of curl", via))
+	}
+	cArgs, secretHeaders, secretFiles, err := secretArgs(ctx, resolver, cArgs, headersFrom, userinfoFrom, token)
+	defer removeTempFiles(secretFiles)
+	if err != nil {
+		return err
+	}
+	redactHeaders(secretHeaders)
+
+	if cacertFrom != "" && hasArg(cArgs, "--cacert", "--capath", "--insecure") {
+		return usageError("--cacert-from cannot be combined with --cacert, --capath, or --insecure")
+	}
+	if certFrom != "" {
+		if hasArg(cArgs, "--cert", "--key") {
+			return usageError("--cert-from cannot be combined with --cert or --key")
+		}
+		files, err := fetchClientCert(ctx, resolver, certFrom)
 		if err != nil {
 			return err
 		}
+		defer files.remove()
 		if debug || isVerbose(cArgs) {
-			_, _ = fmt.Fprintf(os.Stderr, "Found %d pods, using pod/%s\n", len(pods), resolvedPodName)
+			_, _ = fmt.Fprintf(os.Stderr, "Using client certificate from %s\n", certFrom)
 		}
-		podName = resolvedPodName
-		if debug {
-			_, _ = fmt.Fprintf(os.Stderr, "DEBUG: podName set to %q from resource\n", podName)
+		cArgs = append(cArgs, "--cert", files.cert, "--key", files.key)
+		// The CA of the secret is used unless another one was given.
+		if files.caCert != "" && cacertFrom == "" && !hasArg(cArgs, "--cacert", "--capath", "--insecure") {
+			cArgs = append(cArgs, "--cacert", files.caCert)
+		}
+	}
+	if cacertFrom != "" || (requestURL.Scheme == "https" && probe == "" && via == "portforward" && !hasArg(cArgs, "--cacert", "--capath", "--insecure")) {
+		caFile, err := fetchCACert(ctx, resolver, cacertFrom)
+		if err != nil {
+			return err
+		}
+		if caFile != "" {
+			defer os.Remove(caFile)
+			if debug || isVerbose(cArgs) {
+				_, _ = fmt.Fprintf(os.Stderr, "Using CA certificate from %s\n", cacertSource(cacertFrom))
+			}
+			cArgs = append(cArgs, "--cacert", caFile)
 		}
 	}
 
-	log.Printf("kubectl get -n %s pod/%s", namespace, podName)
-	pod, err := client.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	req := curlRequest{
+		client:        client,
+		config:        restConfig,
+		namespace:     namespace,
+		podName:       podName,
+		podPort:       podPort,
+		podPorts:      podPorts,
+		serviceName:   serviceName,
+		sourcePod:     sourcePod,
+		containerName: containerName,
+		requestURL:    *requestURL,
+		args:          cArgs,
+		stdin:         os.Stdin,
+		stdout:        os.Stdout,
+		stderr:        os.Stderr,
+		debugStdout:   stdout,
+		debugStderr:   stderr,
+	}
+
+	if probe != "" {
+		return probePod(ctx, req, probe)
+	}
+	if allPods {
+		return curlAllPods(ctx, req, podNames, concurrency, curlPod)
+	}
+	return curlPod(ctx, req)
+}
+
+// curlRequest carries the parameters of a curl command sent to a single pod.
+type curlRequest struct {
+	client        kubernetes.Interface
+	config        *rest.Config
+	namespace     string
+	podName       string
+	podPort       string
+	podPorts      map[string]string // target port of each endpoint pod of a service, by pod name
+	serviceName   string
+	sourcePod     *corev1.Pod
+	containerName string
+	requestURL    url.URL
+	args          []string
+	stdin         io.Reader
+	stdout        io.Writer
+	stderr        io.Writer
+	debugStdout   io.Writer
+	debugStderr   io.Writer
+}
+
+// curlPod forwards a local port to the pod of req and runs curl against it.
+func curlPod(ctx context.Context, req curlRequest) error {
+	if req.serviceName != "" && req.sourcePod != nil {
+		return curlServiceFrom(ctx, req)
+	}
+	if req.serviceName != "" {
+		return curlServiceThroughAPIServer(ctx, req)
+	}
+	podName, containerName := req.podName, req.containerName
+	requestURL := req.requestURL
+
+	log.Printf("kubectl get -n %s pod/%s", req.namespace, podName)
+	pod, err := req.client.CoreV1().Pods(req.namespace).Get(ctx, podName, metav1.GetOptions{})
 	if err != nil {
 		return err
 	}
@@ -252,41 +629,123 @@ func run(ctx context.Context) error {
 		return fmt.Errorf("unable to forward port because pod is not running. Current status=%v", pod.Status.Phase)
 	}
 
-	const minPort = 10200
-	const maxPort = 16383
-	localPort := rand.Int31n(maxPort-minPort) + minPort
 	remotePort := int32(0)
 	portName := requestURL.Scheme
 
-	if podPort != "" {
-		p, err := strconv.ParseInt(podPort, 10, 32)
+	if req.podPort != "" {
+		p, err := strconv.ParseInt(req.podPort, 10, 32)
 		if err != nil {
-			portName = podPort
+			portName = req.podPort
 		} else {
 			remotePort = int32(p)
 		}
 	}
 
-	if remotePort == 0 {
+	if req.podPort == "" {
+		selectedContainerName, selectedPort, rule, err := inferContainerPort(pod, containerName, portName)
+		if err != nil {
+			return err
+		}
+		if debug || isVerbose(req.args) {
+			_, _ = fmt.Fprintf(req.stderr, "Using port %d of pod/%s (%s)\n", selectedPort, pod.Name, rule)
+		}
+		containerName = selectedContainerName
+		remotePort = selectedPort
+	} else if remotePort == 0 {
 		selectedContainerName, selectedContainerPort, err := selectContainerPort(pod, containerName, portName)
 		if err != nil {
 			return err
 		}
 		containerName = selectedContainerName
 		remotePort = selectedContainerPort.ContainerPort
+	} else if containerName != "" {
+		if _, ok := findContainer(pod, containerName); !ok {
+			return fmt.Errorf("container %s not found in pod %s", containerName, pod.Name)
+		}
+	}
+
+	if via == "apiserver" {
+		proxyURL := apiserverProxyURL(req.client, pod.Namespace, "pods", pod.Name, requestURL.Scheme, strconv.Itoa(int(remotePort)))
+		if debug || isVerbose(req.args) {
+			_, _ = fmt.Fprintf(req.stderr, "Sending the request to port %d of pod/%s through %s\n", remotePort, pod.Name, proxyURL.Path)
+		}
+		return curlThroughAPIServer(ctx, req, proxyURL)
+	}
+	if req.sourcePod != nil {
+		// The destination is addressed by its pod IP from the source pod,
+		// unless the URL has a cluster DNS name.
+		if !strings.Contains(requestURL.Hostname()+".", ".svc.") {
+			req.requestURL.Host = net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(remotePort)))
+		}
+		return curlFrom(ctx, req)
+	}
+	if via == "exec" || via == "debug" {
+		// Requests to the pod IP, like the probes of the kubelet, are sent
+		// to the pod IP rather than the loopback interface.
+		connectPort := remotePort
+		if requestURL.Hostname() == pod.Status.PodIP {
+			connectPort = 0
+		}
+		if via == "exec" {
+			return curlExec(ctx, req, pod, containerName, connectPort)
+		}
+		return curlDebug(ctx, req, pod, containerName, connectPort)
 	}
 
-	log.Printf("forwarding local port %d to port %d of %s", localPort, remotePort, containerName)
+	// The URL is passed to curl unchanged so the Host header and the TLS
+	// server name are the ones that clients in the cluster would send.
+	args := make([]string, 0, len(req.args)+4)
+	args = append(args, req.args...)
+	args = append(args, requestURL.String())
+	// The -s option is taken by -s,--server from the default kubectl
+	// configuration. Force --silent because we don't really need to
+	// print the dynamic progress view for the scenarios in which this
+	// plugin is useful for.
+	args = append(args, "--silent")
+
+	n, err := nativeCurlFor(req, args)
+	if err != nil {
+		return err
+	}
+	if n != nil {
+		// The native executor opens connections to the pod through the
+		// port forwarding streams, without listening on a local port.
+		portForwardDialer, err := curl.NewPortForwardDialer(req.config, pod.Namespace, pod.Name, transport)
+		if err != nil {
+			return err
+		}
+		dialer := curl.NewPodDialerWith(portForwardDialer, pod.Namespace, pod.Name)
+		defer dialer.Close()
+
+		podAddress := net.JoinHostPort(pod.Name, strconv.Itoa(int(remotePort)))
+		n.podHost = requestURL.Hostname()
+		n.dialPod = func(ctx context.Context) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp", podAddress)
+		}
+		log.Printf("native curl %s, dialing port %d of %s", prettyArgs(args), remotePort, containerName)
+		return n.run(ctx, req.stdout, req.stderr)
+	}
+
+	log.Printf("forwarding local port %d on %s to port %d of %s", localPort, strings.Join(addresses, ","), remotePort, containerName)
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	// The errors of the port forwarder are captured to explain why it
+	// failed to listen on the local port.
+	forwarderErrors := new(bytes.Buffer)
+	forwarderStderr := io.Writer(forwarderErrors)
+	if req.debugStderr != nil {
+		forwarderStderr = io.MultiWriter(forwarderErrors, req.debugStderr)
+	}
+
 	f, err := openPortForwarder(ctx, portForwarderConfig{
-		config:     restConfig,
+		config:     req.config,
 		pod:        pod,
-		localPort:  localPort,
+		addresses:  addresses,
+		localPort:  int32(localPort),
 		remotePort: remotePort,
-		stdout:     stdout,
-		stderr:     stderr,
+		stdout:     req.debugStdout,
+		stderr:     forwarderStderr,
 	})
 	if err != nil {
 		return err
@@ -299,42 +758,181 @@ func run(ctx context.Context) error {
 	defer cancel()
 	defer log.Printf("shutting down port forwarder")
 
+	errc := make(chan error, 1)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		defer f.Close()
 
-		if err := f.ForwardPorts(); err != nil {
+		err := f.ForwardPorts()
+		if err != nil {
 			log.Print(err)
 		}
+		errc <- err
 	}()
 
 	log.Printf("waiting for port fowarding to be established")
 	select {
 	case <-f.Ready:
+	case err := <-errc:
+		if err == nil {
+			err = fmt.Errorf("port forwarder stopped")
+		}
+		if details := strings.TrimSpace(forwarderErrors.String()); details != "" {
+			err = fmt.Errorf("%w\n%s", err, details)
+		}
+		return fmt.Errorf("failed to listen on port %d of %s: %w", localPort, strings.Join(addresses, ", "), err)
 	case <-ctx.Done():
 		return nil
 	}
 
-	requestURL.Host = net.JoinHostPort("localhost", strconv.Itoa(int(localPort)))
-	cArgs = append(cArgs, requestURL.String())
-	// The -s option is taken by -s,--server from the default kubectl
-	// configuration. Force --silent because we don't really need to
-	// print the dynamic progress view for the scenarios in which this
-	// plugin is useful for.
-	cArgs = append(cArgs, "--silent")
-
-	cmd := exec.CommandContext(ctx, "curl", cArgs...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	log.Printf("curl %s", prettyArgs(cmd.Args[1:]))
-	return cmd.Run()
+	ports, err := f.GetPorts()
+	if err != nil {
+		return err
+	}
+	forwardedPort := int32(ports[0].Local)
+	if debug || isVerbose(req.args) {
+		_, _ = fmt.Fprintf(req.stderr, "Forwarding local port %d to port %d of pod/%s\n", forwardedPort, remotePort, pod.Name)
+	}
+
+	// The connections of curl are routed to the forwarded port.
+	cArgs := append([]string{"--connect-to", connectTo(requestURL.Hostname(), addresses[0], forwardedPort)}, args...)
+	return execCurl(ctx, req, cArgs)
+}
+
+// resolveTargetContext validates the kubeconfig context selected by the target.
+// A single @ suffix in a resource target is always a node name, contexts are
+// given after a second @, as in NAME@@CONTEXT, so the meaning of a URL does not
+// depend on the contexts of the local kubeconfig.
+func resolveTargetContext(target *curl.ResourceTarget) error {
+	if target.Context == "" {
+		return nil
+	}
+
+	rawConfig, err := config.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return err
+	}
+	if _, ok := rawConfig.Contexts[target.Context]; !ok {
+		return fmt.Errorf("context %s not found in kubeconfig", target.Context)
+	}
+	return nil
+}
+
+// selectsPods reports whether the pods of the request are selected with
+// -l/--selector or --field-selector, which requires the URL host to be omitted
+// or set to _ in place of a pod name or resource.
+func selectsPods(target curl.ResourceTarget, labelSelector, fieldSelector string) (bool, error) {
+	isSelector := !target.IsResource && (target.PodName == "" || target.PodName == "_")
+	if isSelector && labelSelector == "" && fieldSelector == "" {
+		return false, usageError("missing pod name in URL, either set one or select pods with -l/--selector or --field-selector")
+	}
+	if !isSelector && (labelSelector != "" || fieldSelector != "") {
+		return false, usageError("-l/--selector and --field-selector require the URL host to be omitted or set to _")
+	}
+	return isSelector, nil
+}
+
+// requestHost returns the host of the URL passed to curl. Cluster DNS names,
+// pod names and IPs are kept as given, services are addressed by their DNS
+// name, and other resources or selectors by localhost. Named ports are not
+// valid in URLs, the default port of the scheme is used instead.
+func requestHost(requestURL *url.URL, target curl.ResourceTarget, namespace string, isService bool) string {
+	host, port := requestURL.Hostname(), target.PodPort
+	if _, err := strconv.Atoi(port); err != nil {
+		port = ""
+	}
+
+	switch {
+	case target.PodIP != "" || strings.Contains(host+".", ".svc."):
+	case isService:
+		host = target.ResourceName + "." + namespace + ".svc"
+	case !target.IsResource && target.PodName != "" && target.PodName != "_":
+	default:
+		host = "localhost"
+	}
+
+	if port != "" {
+		return net.JoinHostPort(host, port)
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// connectTo returns the value of curl's --connect-to option which routes the
+// connections to any port of host to the local address and port.
+func connectTo(host, localAddress string, localPort int32) string {
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	// Wildcard addresses are reached through the loopback interface.
+	switch localAddress {
+	case "0.0.0.0":
+		localAddress = "127.0.0.1"
+	case "::":
+		localAddress = "::1"
+	}
+	if strings.Contains(localAddress, ":") {
+		localAddress = "[" + localAddress + "]"
+	}
+	return fmt.Sprintf("%s::%s:%d", host, localAddress, localPort)
+}
+
+// renamedOptions maps the names of curl options which conflict with the
+// kubectl options to the names of their flags.
+var renamedOptions = map[string]string{
+	"user":       "userinfo",
+	"local-port": "curl-local-port",
+}
+
+// curlOptionName returns the name of the curl option of a flag, which differs
+// from the name of the flag for renamed options.
+func curlOptionName(flagName string) string {
+	for name, renamed := range renamedOptions {
+		if renamed == flagName {
+			return "--" + name
+		}
+	}
+	return "--" + flagName
+}
+
+// sensitiveArgs are the curl options whose values are redacted by prettyArgs.
+var sensitiveArgs = map[string]bool{
+	"--oauth2-bearer": true,
+	"--pass":          true,
+	"--proxy-user":    true,
+	"--user":          true,
+	"--userinfo":      true,
+}
+
+// sensitiveHeaders are the headers whose values are redacted by prettyArgs
+// and the verbose output of the native executor, by lower case name.
+var sensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+}
+
+// redactHeaders adds headers to the sensitive headers, for example those whose
+// values are read from secrets.
+func redactHeaders(headers []string) {
+	for _, name := range headers {
+		sensitiveHeaders[strings.ToLower(strings.TrimSpace(name))] = true
+	}
 }
 
 func prettyArgs(slice []string) string {
 	out := ""
 	for i, s := range slice {
+		if i > 0 && sensitiveArgs[slice[i-1]] {
+			s = "<redacted>"
+		}
+		if name, _, ok := strings.Cut(s, ":"); ok && i > 0 && slice[i-1] == "--header" && sensitiveHeaders[strings.ToLower(strings.TrimSpace(name))] {
+			s = name + ": <redacted>"
+		}
 		if strings.Contains(s, " ") {
 			out += fmt.Sprintf("%q", s) // add quotes when known
 		} else {
@@ -347,33 +945,10 @@ func prettyArgs(slice []string) string {
 	return out
 }
 
-func selectContainerPort(pod *corev1.Pod, containerName, portName string) (selectedContainerName string, selectedContainerPort corev1.ContainerPort, err error) {
-	for _, container := range pod.Spec.Containers {
-		if containerName != "" && container.Name != containerName {
-			continue
-		}
-		for _, port := range container.Ports {
-			if port.Name != portName || port.Protocol != corev1.ProtocolTCP {
-				continue
-			}
-			if selectedContainerPort.Name != "" {
-				err = fmt.Errorf("pod %[1]s has multiple containers with a %[2]s port, use kubectl %[1]s [container] to specify which one to profile",
-					pod.Name, portName)
-				return
-			}
-			selectedContainerName = container.Name
-			selectedContainerPort = port
-		}
-	}
-	if selectedContainerPort.Name == "" {
-		err = fmt.Errorf("pod %s had no containers exposing a %s port", pod.Name, portName)
-	}
-	return
-}
-
 type portForwarderConfig struct {
 	config     *rest.Config
 	pod        *corev1.Pod
+	addresses  []string
 	localPort  int32
 	remotePort int32
 	stdout     io.Writer
@@ -381,24 +956,11 @@ type portForwarderConfig struct {
 }
 
 func openPortForwarder(ctx context.Context, fwd portForwarderConfig) (*portforward.PortForwarder, error) {
-	transport, upgrader, err := spdy.RoundTripperFor(fwd.config)
+	dialer, err := curl.NewPortForwardDialer(fwd.config, fwd.pod.Namespace, fwd.pod.Name, transport)
 	if err != nil {
 		return nil, err
 	}
 
-	host := strings.TrimLeft(fwd.config.Host, "htps:/")
-	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/portforward", fwd.pod.Namespace, fwd.pod.Name)
-
-	client := &http.Client{
-		Transport: transport,
-	}
-
-	dialer := spdy.NewDialer(upgrader, client, http.MethodPost, &url.URL{
-		Scheme: "https",
-		Host:   host,
-		Path:   path,
-	})
-
 	ports := []string{
 		fmt.Sprintf("%d:%d", fwd.localPort, fwd.remotePort),
 	}
@@ -411,62 +973,7 @@ func openPortForwarder(ctx context.Context, fwd portForwarderConfig) (*portforwa
 		fwd.stderr = io.Discard
 	}
 
-	return portforward.New(dialer, ports, ctx.Done(), make(chan struct{}), fwd.stdout, fwd.stderr)
-}
-
-// resourceTypeMap maps supported resource types and their abbreviations to canonical names
-var resourceTypeMap = map[string]string{
-	"deployment":   "deployment",
-	"deploy":       "deployment",
-	"deployments":  "deployment",
-	"ds":           "daemonset",
-	"daemonset":    "daemonset",
-	"daemonsets":   "daemonset",
-	"sts":          "statefulset",
-	"statefulset":  "statefulset",
-	"statefulsets": "statefulset",
-}
-
-// resolvePodFromResource finds a pod name for a given resource type and name in a namespace.
-func resolvePodFromResource(ctx context.Context, client *kubernetes.Clientset, namespace, resourceType, resourceName string) ([]corev1.Pod, string, error) {
-	canonicalType, ok := resourceTypeMap[strings.ToLower(resourceType)]
-	if !ok {
-		return nil, "", fmt.Errorf("unsupported resource type: %s", resourceType)
-	}
-	var labelSelector string
-	var err error
-
-	switch canonicalType {
-	case "deployment":
-		deployment, err := client.AppsV1().Deployments(namespace).Get(ctx, resourceName, metav1.GetOptions{})
-		if err != nil {
-			return nil, "", fmt.Errorf("failed to get deployment %s: %w", resourceName, err)
-		}
-		labelSelector = metav1.FormatLabelSelector(deployment.Spec.Selector)
-	case "daemonset":
-		daemonset, err := client.AppsV1().DaemonSets(namespace).Get(ctx, resourceName, metav1.GetOptions{})
-		if err != nil {
-			return nil, "", fmt.Errorf("failed to get daemonset %s: %w", resourceName, err)
-		}
-		labelSelector = metav1.FormatLabelSelector(daemonset.Spec.Selector)
-	case "statefulset":
-		sts, err := client.AppsV1().StatefulSets(namespace).Get(ctx, resourceName, metav1.GetOptions{})
-		if err != nil {
-			return nil, "", fmt.Errorf("failed to get statefulset %s: %w", resourceName, err)
-		}
-		labelSelector = metav1.FormatLabelSelector(sts.Spec.Selector)
-	}
-
-	podsList, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: labelSelector,
-	})
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to list pods for %s %s: %w", canonicalType, resourceName, err)
-	}
-	if len(podsList.Items) == 0 {
-		return nil, "", fmt.Errorf("no pods found for %s %s", canonicalType, resourceName)
-	}
-	return podsList.Items, podsList.Items[0].Name, nil
+	return portforward.NewOnAddresses(dialer, fwd.addresses, ports, ctx.Done(), make(chan struct{}), fwd.stdout, fwd.stderr)
 }
 
 // isVerbose checks if -v or --verbose is present in curl args
@@ -479,6 +986,18 @@ func isVerbose(args []string) bool {
 	return false
 }
 
+// hasArg checks if any of the options is present in curl args
+func hasArg(args []string, names ...string) bool {
+	for _, arg := range args {
+		for _, name := range names {
+			if arg == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type usageError string
 
 func (e usageError) Error() string {
@@ -490,6 +1009,7 @@ func usage(msg string) string {
 
 Usage:
   kubectl curl [options] URL [container]
+  kubectl curl [options] --probe readiness|liveness|startup POD [container]
 `
 }
 
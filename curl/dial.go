@@ -0,0 +1,300 @@
+package curl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// Transports of the port forwarding connections to the API server.
+const (
+	// TransportAuto tries the WebSocket transport, and falls back to SPDY
+	// when the API server or a proxy in front of it does not upgrade the
+	// connection to WebSocket.
+	TransportAuto = "auto"
+	// TransportWebSocket tunnels the port forwarding protocol over a
+	// WebSocket connection (portforward.v2), supported since Kubernetes 1.30.
+	TransportWebSocket = "websocket"
+	// TransportSPDY upgrades the connection to SPDY/3.1.
+	TransportSPDY = "spdy"
+)
+
+// NewPortForwardDialer returns a dialer which upgrades connections to the
+// portforward subresource of the pod of the given namespace and name, with one
+// of the TransportAuto, TransportWebSocket, or TransportSPDY transports.
+func NewPortForwardDialer(config *rest.Config, namespace, name, transport string) (httpstream.Dialer, error) {
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	portForwardURL := client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(name).
+		SubResource("portforward").
+		URL()
+
+	var spdyDialer, websocketDialer httpstream.Dialer
+	if transport == TransportAuto || transport == TransportSPDY {
+		roundTripper, upgrader, err := spdy.RoundTripperFor(config)
+		if err != nil {
+			return nil, err
+		}
+		spdyDialer = spdy.NewDialer(upgrader, &http.Client{Transport: roundTripper}, http.MethodPost, portForwardURL)
+	}
+	if transport == TransportAuto || transport == TransportWebSocket {
+		websocketDialer, err = portforward.NewSPDYOverWebsocketDialer(portForwardURL, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch transport {
+	case TransportAuto:
+		return portforward.NewFallbackDialer(websocketDialer, spdyDialer, httpstream.IsUpgradeFailure), nil
+	case TransportWebSocket:
+		return websocketDialer, nil
+	case TransportSPDY:
+		return spdyDialer, nil
+	}
+	return nil, fmt.Errorf("unsupported port forwarding transport: %q (expected auto, websocket, or spdy)", transport)
+}
+
+// PodDialer opens connections to the ports of a pod through the portforward
+// subresource of the API server, without listening on a local port.
+//
+// All the connections opened by a PodDialer are streams multiplexed over a
+// single connection to the API server, which is established on the first call
+// to DialContext, and established again if it was lost.
+type PodDialer struct {
+	dialer    httpstream.Dialer
+	namespace string
+	name      string
+
+	mutex     sync.Mutex
+	conn      httpstream.Connection
+	requestID int
+}
+
+// NewPodDialer returns a PodDialer for the pod of the given namespace and name,
+// connecting to the API server of config with the TransportAuto transport.
+func NewPodDialer(config *rest.Config, namespace, name string) (*PodDialer, error) {
+	dialer, err := NewPortForwardDialer(config, namespace, name, TransportAuto)
+	if err != nil {
+		return nil, err
+	}
+	return NewPodDialerWith(dialer, namespace, name), nil
+}
+
+// NewPodDialerWith returns a PodDialer which upgrades connections to the
+// portforward subresource of a pod with dialer.
+func NewPodDialerWith(dialer httpstream.Dialer, namespace, name string) *PodDialer {
+	return &PodDialer{dialer: dialer, namespace: namespace, name: name}
+}
+
+// DialContext opens a connection to a port of the pod. The network must be a
+// TCP network, and the port of address is the port of the pod that the
+// connection is opened to, its host is ignored.
+//
+// The context bounds the time it takes to connect, as with net.Dialer. The
+// returned connections do not support deadlines, requests are canceled by
+// closing them instead, which is what net/http does.
+func (d *PodDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("unsupported network %q, pods can only be dialed over tcp", network)
+	}
+	_, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil || port == 0 {
+		return nil, fmt.Errorf("invalid port in address %q", address)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan dialResult, 1)
+	go func() {
+		conn, err := d.dial(int(port), portString)
+		results <- dialResult{conn, err}
+	}()
+
+	select {
+	case r := <-results:
+		return r.conn, r.err
+	case <-ctx.Done():
+		// The connection to the API server cannot be interrupted, the
+		// stream is closed if it is opened after the context was done.
+		go func() {
+			if r := <-results; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// dial opens the streams of a connection to the port of the pod.
+func (d *PodDialer) dial(port int, portString string) (net.Conn, error) {
+	conn, requestID, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := http.Header{}
+	headers.Set(corev1.StreamType, corev1.StreamTypeError)
+	headers.Set(corev1.PortHeader, portString)
+	headers.Set(corev1.PortForwardRequestIDHeader, strconv.Itoa(requestID))
+	errorStream, err := conn.CreateStream(headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create error stream to port %d of pod %s: %w", port, d.name, err)
+	}
+	// The error stream is only read from.
+	errorStream.Close()
+
+	headers.Set(corev1.StreamType, corev1.StreamTypeData)
+	dataStream, err := conn.CreateStream(headers)
+	if err != nil {
+		conn.RemoveStreams(errorStream)
+		return nil, fmt.Errorf("failed to create data stream to port %d of pod %s: %w", port, d.name, err)
+	}
+
+	c := &podConn{
+		conn:        conn,
+		dataStream:  dataStream,
+		errorStream: errorStream,
+		errc:        make(chan error, 1),
+		remoteAddr:  podAddr{namespace: d.namespace, name: d.name, port: port},
+	}
+	go func() {
+		message, err := io.ReadAll(errorStream)
+		switch {
+		case err != nil:
+			c.errc <- fmt.Errorf("failed to read error stream of port %d of pod %s: %w", port, d.name, err)
+		case len(message) != 0:
+			c.errc <- fmt.Errorf("failed to forward port %d of pod %s: %s", port, d.name, message)
+		}
+		close(c.errc)
+	}()
+	return c, nil
+}
+
+// connect returns the connection to the API server, establishing it if it was
+// never established or was closed, and the ID of the next port forwarding
+// request.
+func (d *PodDialer) connect() (httpstream.Connection, int, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.conn != nil {
+		select {
+		case <-d.conn.CloseChan():
+			d.conn = nil
+		default:
+		}
+	}
+
+	if d.conn == nil {
+		conn, _, err := d.dialer.Dial(portforward.PortForwardProtocolV1Name)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to connect to pod %s: %w", d.name, err)
+		}
+		d.conn = conn
+	}
+
+	requestID := d.requestID
+	d.requestID++
+	return d.conn, requestID, nil
+}
+
+// Close closes the connection to the API server, and all the connections that
+// were opened to the pod.
+func (d *PodDialer) Close() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.conn == nil {
+		return nil
+	}
+	err := d.conn.Close()
+	d.conn = nil
+	return err
+}
+
+// podConn is a connection to a port of a pod, made of the data and error
+// streams of a port forwarding request.
+type podConn struct {
+	conn        httpstream.Connection
+	dataStream  httpstream.Stream
+	errorStream httpstream.Stream
+	errc        chan error
+	remoteAddr  podAddr
+	closeOnce   sync.Once
+}
+
+func (c *podConn) Read(b []byte) (int, error) {
+	n, err := c.dataStream.Read(b)
+	if err == io.EOF {
+		// The error stream reports why the pod closed the connection, for
+		// example when nothing listens on the port.
+		if streamErr := <-c.errc; streamErr != nil {
+			err = streamErr
+		}
+	}
+	return n, err
+}
+
+func (c *podConn) Write(b []byte) (int, error) {
+	return c.dataStream.Write(b)
+}
+
+func (c *podConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.dataStream.Reset()
+		c.errorStream.Reset()
+		c.conn.RemoveStreams(c.dataStream, c.errorStream)
+	})
+	return nil
+}
+
+func (c *podConn) LocalAddr() net.Addr                { return podAddr{} }
+func (c *podConn) RemoteAddr() net.Addr               { return c.remoteAddr }
+func (c *podConn) SetDeadline(t time.Time) error      { return nil }
+func (c *podConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *podConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// podAddr is the address of a port of a pod.
+type podAddr struct {
+	namespace string
+	name      string
+	port      int
+}
+
+func (a podAddr) Network() string { return "portforward" }
+
+func (a podAddr) String() string {
+	if a.name == "" {
+		return ""
+	}
+	return a.namespace + "/" + a.name + ":" + strconv.Itoa(a.port)
+}
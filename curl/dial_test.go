@@ -0,0 +1,217 @@
+package curl
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+)
+
+type fakeStream struct {
+	net.Conn
+	headers http.Header
+}
+
+func (s *fakeStream) Reset() error         { return s.Conn.Close() }
+func (s *fakeStream) Headers() http.Header { return s.headers }
+func (s *fakeStream) Identifier() uint32   { return 0 }
+func (s *fakeStream) Close() error         { return s.Conn.(*net.TCPConn).CloseWrite() }
+
+// fakeConnection serves the data streams with a handler, and reports
+// errorMessage on the error streams.
+type fakeConnection struct {
+	mutex        sync.Mutex
+	streams      []http.Header
+	errorMessage string
+	closed       chan bool
+}
+
+func (c *fakeConnection) CreateStream(headers http.Header) (httpstream.Stream, error) {
+	c.mutex.Lock()
+	c.streams = append(c.streams, headers.Clone())
+	c.mutex.Unlock()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+
+	streamType := headers.Get(corev1.StreamType)
+	go func() {
+		server, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer server.Close()
+		if streamType == corev1.StreamTypeError {
+			io.WriteString(server, c.errorMessage)
+			return
+		}
+		if c.errorMessage == "" {
+			io.Copy(server, server)
+		}
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	return &fakeStream{Conn: client, headers: headers}, nil
+}
+
+func (c *fakeConnection) Close() error                       { return nil }
+func (c *fakeConnection) CloseChan() <-chan bool             { return c.closed }
+func (c *fakeConnection) SetIdleTimeout(time.Duration)       {}
+func (c *fakeConnection) RemoveStreams(...httpstream.Stream) {}
+
+type fakeDialer struct {
+	conn  *fakeConnection
+	dials int
+	block chan struct{}
+}
+
+func (d *fakeDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	d.dials++
+	if d.block != nil {
+		<-d.block
+	}
+	return d.conn, protocols[0], nil
+}
+
+func TestPodDialer(t *testing.T) {
+	conn := &fakeConnection{closed: make(chan bool)}
+	dialer := &fakeDialer{conn: conn}
+	d := NewPodDialerWith(dialer, "default", "mypod")
+	defer d.Close()
+
+	for i := 0; i < 2; i++ {
+		c, err := d.DialContext(context.Background(), "tcp", "mypod:8080")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(c, "hello"); err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 5)
+		if _, err := io.ReadFull(c, b); err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "hello" {
+			t.Errorf("unexpected response: %q", b)
+		}
+		if addr := c.RemoteAddr().String(); addr != "default/mypod:8080" {
+			t.Errorf("unexpected remote address: %s", addr)
+		}
+		c.Close()
+	}
+
+	if dialer.dials != 1 {
+		t.Errorf("connections to the API server were not reused: %d dials", dialer.dials)
+	}
+	if len(conn.streams) != 4 {
+		t.Fatalf("unexpected number of streams: %d", len(conn.streams))
+	}
+	for i, headers := range conn.streams {
+		if port := headers.Get(corev1.PortHeader); port != "8080" {
+			t.Errorf("stream %d: unexpected port: %s", i, port)
+		}
+		if requestID, want := headers.Get(corev1.PortForwardRequestIDHeader), []string{"0", "1"}[i/2]; requestID != want {
+			t.Errorf("stream %d: unexpected request ID: want=%s got=%s", i, want, requestID)
+		}
+	}
+}
+
+func TestPodDialerError(t *testing.T) {
+	conn := &fakeConnection{closed: make(chan bool), errorMessage: "connection refused"}
+	d := NewPodDialerWith(&fakeDialer{conn: conn}, "default", "mypod")
+	defer d.Close()
+
+	c, err := d.DialContext(context.Background(), "tcp", "mypod:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := io.ReadAll(c); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("expected the error of the error stream, got %v", err)
+	}
+
+	if _, err := d.DialContext(context.Background(), "udp", "mypod:53"); err == nil {
+		t.Error("expected an error dialing a udp port")
+	}
+}
+
+func TestPodDialerContext(t *testing.T) {
+	conn := &fakeConnection{closed: make(chan bool)}
+	dialer := &fakeDialer{conn: conn, block: make(chan struct{})}
+	d := NewPodDialerWith(dialer, "default", "mypod")
+	defer d.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := d.DialContext(ctx, "tcp", "mypod:8080"); err != context.DeadlineExceeded {
+		t.Errorf("expected the dial to time out, got %v", err)
+	}
+	close(dialer.block)
+}
+
+// upgradeRecorder is an API server which refuses to upgrade connections, and
+// records the protocols that the clients asked to upgrade to.
+type upgradeRecorder struct {
+	mutex    sync.Mutex
+	upgrades []string
+}
+
+func (r *upgradeRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	r.upgrades = append(r.upgrades, req.Header.Get("Upgrade"))
+	r.mutex.Unlock()
+	http.Error(w, "upgrade refused", http.StatusForbidden)
+}
+
+func TestNewPortForwardDialer(t *testing.T) {
+	tests := []struct {
+		transport string
+		upgrades  []string
+	}{
+		{transport: TransportAuto, upgrades: []string{"websocket", "SPDY/3.1"}},
+		{transport: TransportWebSocket, upgrades: []string{"websocket"}},
+		{transport: TransportSPDY, upgrades: []string{"SPDY/3.1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.transport, func(t *testing.T) {
+			recorder := new(upgradeRecorder)
+			server := httptest.NewServer(recorder)
+			defer server.Close()
+
+			dialer, err := NewPortForwardDialer(&rest.Config{Host: server.URL}, "ns", "pod", test.transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name); err == nil {
+				t.Fatal("expected the upgrade to fail")
+			}
+			if !reflect.DeepEqual(recorder.upgrades, test.upgrades) {
+				t.Errorf("upgrades mismatch: want=%q got=%q", test.upgrades, recorder.upgrades)
+			}
+		})
+	}
+
+	_, err := NewPortForwardDialer(&rest.Config{Host: "https://localhost"}, "ns", "pod", "http2")
+	if want := `unsupported port forwarding transport: "http2" (expected auto, websocket, or spdy)`; err == nil || err.Error() != want {
+		t.Errorf("error mismatch: want=%q got=%v", want, err)
+	}
+}
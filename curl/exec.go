@@ -0,0 +1,67 @@
+package curl
+
+import (
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// NewExecExecutor returns an executor which runs a command in a container of
+// the pod of the given namespace and name through the exec subresource, with
+// one of the TransportAuto, TransportWebSocket, or TransportSPDY transports.
+func NewExecExecutor(config *rest.Config, namespace, name string, options *corev1.PodExecOptions, transport string) (remotecommand.Executor, error) {
+	return newPodExecutor(config, namespace, name, "exec", options, transport)
+}
+
+// NewAttachExecutor returns an executor which attaches to the process of a
+// container of the pod of the given namespace and name through the attach
+// subresource, with one of the TransportAuto, TransportWebSocket, or
+// TransportSPDY transports.
+func NewAttachExecutor(config *rest.Config, namespace, name string, options *corev1.PodAttachOptions, transport string) (remotecommand.Executor, error) {
+	return newPodExecutor(config, namespace, name, "attach", options, transport)
+}
+
+func newPodExecutor(config *rest.Config, namespace, name, subresource string, options runtime.Object, transport string) (remotecommand.Executor, error) {
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	executorURL := client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(name).
+		SubResource(subresource).
+		VersionedParams(options, scheme.ParameterCodec).
+		URL()
+
+	var spdyExecutor, websocketExecutor remotecommand.Executor
+	if transport == TransportAuto || transport == TransportSPDY {
+		spdyExecutor, err = remotecommand.NewSPDYExecutor(config, http.MethodPost, executorURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if transport == TransportAuto || transport == TransportWebSocket {
+		websocketExecutor, err = remotecommand.NewWebSocketExecutor(config, http.MethodGet, executorURL.String())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch transport {
+	case TransportAuto:
+		return remotecommand.NewFallbackExecutor(websocketExecutor, spdyExecutor, httpstream.IsUpgradeFailure)
+	case TransportWebSocket:
+		return websocketExecutor, nil
+	case TransportSPDY:
+		return spdyExecutor, nil
+	}
+	return nil, fmt.Errorf("unsupported %s transport: %q (expected auto, websocket, or spdy)", subresource, transport)
+}
@@ -0,0 +1,59 @@
+package curl
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+func TestNewPodExecutor(t *testing.T) {
+	executors := map[string]func(config *rest.Config, transport string) (remotecommand.Executor, error){
+		"exec": func(config *rest.Config, transport string) (remotecommand.Executor, error) {
+			return NewExecExecutor(config, "ns", "pod", &corev1.PodExecOptions{Command: []string{"curl"}, Stdout: true}, transport)
+		},
+		"attach": func(config *rest.Config, transport string) (remotecommand.Executor, error) {
+			return NewAttachExecutor(config, "ns", "pod", &corev1.PodAttachOptions{Container: "curl", Stdout: true}, transport)
+		},
+	}
+	tests := []struct {
+		transport string
+		upgrades  []string
+	}{
+		{transport: TransportAuto, upgrades: []string{"websocket", "SPDY/3.1"}},
+		{transport: TransportWebSocket, upgrades: []string{"websocket"}},
+		{transport: TransportSPDY, upgrades: []string{"SPDY/3.1"}},
+	}
+
+	for subresource, newExecutor := range executors {
+		for _, test := range tests {
+			t.Run(subresource+"/"+test.transport, func(t *testing.T) {
+				recorder := new(upgradeRecorder)
+				server := httptest.NewServer(recorder)
+				defer server.Close()
+
+				executor, err := newExecutor(&rest.Config{Host: server.URL}, test.transport)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = executor.StreamWithContext(context.Background(), remotecommand.StreamOptions{Stdout: io.Discard})
+				if err == nil {
+					t.Fatal("expected the upgrade to fail")
+				}
+				if !reflect.DeepEqual(recorder.upgrades, test.upgrades) {
+					t.Errorf("upgrades mismatch: want=%q got=%q", test.upgrades, recorder.upgrades)
+				}
+			})
+		}
+
+		_, err := newExecutor(&rest.Config{Host: "https://localhost"}, "http2")
+		if want := `unsupported ` + subresource + ` transport: "http2" (expected auto, websocket, or spdy)`; err == nil || err.Error() != want {
+			t.Errorf("error mismatch: want=%q got=%v", want, err)
+		}
+	}
+}
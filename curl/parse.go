@@ -1,72 +1,287 @@
 package curl
 
 import (
+	"fmt"
+	"net"
 	"net/url"
+	"strconv"
 	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// ResourceTarget describes the pod or resource that a URL given to kubectl curl
+// refers to. The host of the URL, and for resource targets the first segments
+// of its path, follow the grammar:
+//
+//	target    = resource | pod
+//	resource  = TYPE "/" NAME [ "." NAMESPACE ] qualifier [ port ]
+//	          | NAMESPACES "/" NAMESPACE "/" TYPE "/" NAME qualifier [ port ]
+//	pod       = ( NAME [ "." NAMESPACE ] | DNS-NAME | IP | "_" | "" ) [ ":" PORT ]
+//	qualifier = [ "@" [ NODE ] [ "@" CONTEXT ] ]
+//	port      = ":" [ CONTAINER ":" ] PORT
+//
+// TYPE is any resource type, short name or category known to the lookup
+// function passed to ParseResourceTarget, and PORT is a port number or name.
+// NAMESPACES is one of the literal hosts ns, namespace or namespaces, like the
+// paths of the API server; a host without it is a pod even when the first
+// segment of the path is a resource type, so http://my-api/jobs/42 targets the
+// pod my-api.
+// Cluster DNS names and pod IPs are described in parseHostName.
+//
+// A single @ suffix is always reported as NodeName, a context is given after a
+// second @, as in NAME@@CONTEXT when no node is given.
 type ResourceTarget struct {
-	IsResource   bool
-	ResourceType string
-	ResourceName string
-	PodName      string
-	PodPort      string
-	NewPath      string
+	IsResource    bool
+	ResourceType  string
+	ResourceName  string
+	NodeName      string
+	PodName       string
+	PodIP         string
+	PodPort       string
+	ContainerName string
+	Namespace     string
+	Context       string
+	NewPath       string
+}
+
+// namespacesHosts are the hosts which introduce a namespace in the path of a
+// URL, the same way as the namespaces segment of API server paths.
+var namespacesHosts = map[string]bool{
+	"ns":         true,
+	"namespace":  true,
+	"namespaces": true,
+}
+
+// ResourceTypeLookup returns the canonical name of a resource type given by its
+// name, abbreviation or category, and whether the type is known.
+type ResourceTypeLookup func(resourceType string) (string, bool)
+
+// TargetError is returned by ParseResourceTarget when a URL does not match the
+// target grammar.
+type TargetError struct {
+	Target string // the target part of the URL
+	Field  string // the part of the target which is invalid, e.g. "port"
+	Value  string // the invalid value
+	Reason string
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf("invalid %s %q in target %q: %s", e.Field, e.Value, e.Target, e.Reason)
 }
 
 // ParseResourceTarget parses the URL and returns resource/pod targeting info.
-func ParseResourceTarget(requestURL *url.URL, resourceTypeMap map[string]string) ResourceTarget {
+func ParseResourceTarget(requestURL *url.URL, lookupResourceType ResourceTypeLookup) (ResourceTarget, error) {
+	if requestURL.Host == "" && (requestURL.Scheme == "" || requestURL.Opaque != "") {
+		// URLs given without a scheme, such as "mypod:8080" or "ds/myds",
+		// do not have a host part; parse them again the way run does.
+		if u, err := url.Parse("http://" + requestURL.String()); err == nil {
+			requestURL = u
+		}
+	}
+
 	hostPort := requestURL.Host
-	var podName, podPort string
-	var resourceType, resourceName string
-	isResource := false
-	newPath := requestURL.Path
-
-	if canonicalType, ok := resourceTypeMap[strings.ToLower(hostPort)]; ok && requestURL.Path != "" {
-		segments := strings.SplitN(strings.TrimLeft(requestURL.Path, "/"), "/", 2)
-		resourceAndMaybePort := segments[0]
-		resourceType = canonicalType
-		if colonIdx := strings.LastIndex(resourceAndMaybePort, ":"); colonIdx > -1 {
-			resourceName = resourceAndMaybePort[:colonIdx]
-			podPort = resourceAndMaybePort[colonIdx+1:]
-		} else {
-			resourceName = resourceAndMaybePort
+	target := ResourceTarget{NewPath: requestURL.Path}
+	segments := strings.SplitN(strings.TrimLeft(requestURL.Path, "/"), "/", 3)
+
+	if canonicalType, ok := lookupResourceType(hostPort); ok && requestURL.Path != "" {
+		// TYPE/NAME: the host is the resource type, and the first path
+		// segment is the resource name.
+		target.IsResource = true
+		target.ResourceType = canonicalType
+		target.NewPath = joinPath(segments[1:])
+		return target, target.parseResourceName(hostPort+"/"+segments[0], segments[0])
+	}
+
+	if namespacesHosts[hostPort] {
+		// NAMESPACES/NAMESPACE/TYPE/NAME: the namespace, resource type and
+		// name are the first segments of the path.
+		parts := strings.SplitN(strings.TrimLeft(requestURL.Path, "/"), "/", 4)
+		source := hostPort + "/" + strings.Join(parts[:min(len(parts), 3)], "/")
+		if len(parts) < 3 || parts[0] == "" || parts[1] == "" {
+			return target, &TargetError{Target: source, Field: "path", Value: requestURL.Path, Reason: "expected " + hostPort + "/NAMESPACE/TYPE/NAME"}
 		}
-		isResource = true
-		if len(segments) > 1 {
-			newPath = "/" + segments[1]
-		} else {
-			newPath = "/"
+		canonicalType, ok := lookupResourceType(parts[1])
+		if !ok {
+			return target, &TargetError{Target: source, Field: "resource type", Value: parts[1], Reason: "unknown resource type"}
 		}
-	} else if idx := strings.Index(hostPort, "/"); idx >= 0 {
-		resourceAndMaybePort := hostPort
-		resource := resourceAndMaybePort
-		if colonIdx := strings.LastIndex(resourceAndMaybePort, ":"); colonIdx > -1 && colonIdx > idx {
-			resource = resourceAndMaybePort[:colonIdx]
-			podPort = resourceAndMaybePort[colonIdx+1:]
+		target.IsResource = true
+		target.Namespace = parts[0]
+		target.ResourceType = canonicalType
+		target.NewPath = joinPath(parts[3:])
+		if err := validateName(source, "namespace", target.Namespace, validation.IsDNS1123Label); err != nil {
+			return target, err
+		}
+		return target, target.parseResourceName(source, parts[2])
+	}
+
+	// NAME[.NAMESPACE][:PORT], cluster DNS names or pod IPs
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host, port = hostPort, ""
+	}
+	target.PodPort = port
+	return target, target.parseHostName(hostPort, host, lookupResourceType)
+}
+
+// parseHostName parses the host of a URL which does not reference a resource.
+// In addition to NAME[.NAMESPACE], it recognizes the names that the cluster DNS
+// gives to services and pods, so in-cluster URLs can be used as is:
+//
+//   - SERVICE.NAMESPACE.svc[.CLUSTER-DOMAIN] targets a service
+//   - POD.SUBDOMAIN.NAMESPACE.svc[.CLUSTER-DOMAIN] targets the pod of a
+//     StatefulSet through its headless service
+//   - A-B-C-D.NAMESPACE.pod[.CLUSTER-DOMAIN] and IPv4 or IPv6 addresses target
+//     a pod by IP
+func (t *ResourceTarget) parseHostName(source, host string, lookupResourceType ResourceTypeLookup) error {
+	if err := validatePort(source, t.PodPort); err != nil {
+		return err
+	}
+
+	host = strings.TrimSuffix(host, ".")
+
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		t.PodIP = ip.String()
+		return nil
+	}
+
+	if host == "" || host == "_" {
+		// The pods are selected with label or field selectors.
+		t.PodName = host
+		return nil
+	}
+
+	labels := strings.Split(host, ".")
+	switch {
+	case len(labels) >= 3 && labels[2] == "svc":
+		t.IsResource = true
+		t.ResourceType = serviceType(lookupResourceType)
+		t.ResourceName, t.Namespace = labels[0], labels[1]
+
+	case len(labels) >= 4 && labels[3] == "svc":
+		t.PodName, t.Namespace = labels[0], labels[2]
+
+	case len(labels) >= 3 && labels[2] == "pod":
+		ip := net.ParseIP(strings.ReplaceAll(labels[0], "-", "."))
+		if ip == nil {
+			ip = net.ParseIP(strings.ReplaceAll(labels[0], "-", ":"))
 		}
-		resourceParts := strings.SplitN(resource, "/", 2)
-		if len(resourceParts) == 2 {
-			resourceType, resourceName = resourceParts[0], resourceParts[1]
-			isResource = true
+		if ip == nil {
+			return &TargetError{Target: source, Field: "pod IP", Value: labels[0], Reason: "not an IP address in A-B-C-D form"}
+		}
+		t.PodIP, t.Namespace = ip.String(), labels[1]
+		return validateName(source, "namespace", t.Namespace, validation.IsDNS1123Label)
+
+	default:
+		t.PodName, t.Namespace = splitNamespace(host)
+	}
+
+	if t.IsResource {
+		if err := validateName(source, "name", t.ResourceName, validation.IsDNS1123Subdomain); err != nil {
+			return err
 		}
 	} else {
-		// podname[:port]
-		parts := strings.SplitN(hostPort, ":", 2)
-		podName = parts[0]
-		if len(parts) == 2 {
-			podPort = parts[1]
-		} else {
-			podPort = ""
+		if err := validateName(source, "pod name", t.PodName, validation.IsDNS1123Subdomain); err != nil {
+			return err
+		}
+	}
+	return validateName(source, "namespace", t.Namespace, validation.IsDNS1123Label)
+}
+
+func serviceType(lookupResourceType ResourceTypeLookup) string {
+	if canonicalType, ok := lookupResourceType("services"); ok {
+		return canonicalType
+	}
+	return "services"
+}
+
+// parseResourceName parses the NAME[.NAMESPACE][@[NODE][@CONTEXT]][:[CONTAINER:]PORT]
+// part of a resource target.
+func (t *ResourceTarget) parseResourceName(source, s string) error {
+	if i := strings.Index(s, ":"); i >= 0 {
+		s, t.PodPort = s[:i], s[i+1:]
+		if i := strings.Index(t.PodPort, ":"); i >= 0 {
+			t.ContainerName, t.PodPort = t.PodPort[:i], t.PodPort[i+1:]
+			if err := validateName(source, "container name", t.ContainerName, validation.IsDNS1123Label); err != nil {
+				return err
+			}
 		}
+		if err := validatePort(source, t.PodPort); err != nil {
+			return err
+		}
+	}
+
+	qualifiers := strings.Split(s, "@")
+	switch len(qualifiers) {
+	case 1:
+	case 2, 3:
+		t.NodeName = qualifiers[1]
+		if len(qualifiers) == 3 {
+			t.Context = qualifiers[2]
+			if t.Context == "" {
+				return &TargetError{Target: source, Field: "context", Value: t.Context, Reason: "must not be empty"}
+			}
+		}
+		if t.NodeName != "" || t.Context == "" {
+			if err := validateName(source, "node name", t.NodeName, validation.IsDNS1123Subdomain); err != nil {
+				return err
+			}
+		}
+	default:
+		return &TargetError{Target: source, Field: "name", Value: s, Reason: "expected at most a node and a context after the name, in the form NAME@NODE@CONTEXT"}
 	}
 
-	return ResourceTarget{
-		IsResource:   isResource,
-		ResourceType: resourceType,
-		ResourceName: resourceName,
-		PodName:      podName,
-		PodPort:      podPort,
-		NewPath:      newPath,
+	if t.Namespace == "" {
+		t.ResourceName, t.Namespace = splitNamespace(qualifiers[0])
+		if err := validateName(source, "namespace", t.Namespace, validation.IsDNS1123Label); err != nil {
+			return err
+		}
+	} else {
+		t.ResourceName = qualifiers[0]
+	}
+
+	return validateName(source, "name", t.ResourceName, validation.IsDNS1123Subdomain)
+}
+
+// validateName validates a name with one of the functions of the validation
+// package. Empty namespaces are valid, since they default to the namespace of
+// the kubeconfig context.
+func validateName(source, field, value string, validate func(string) []string) error {
+	if field == "namespace" && value == "" {
+		return nil
+	}
+	if errs := validate(value); len(errs) != 0 {
+		return &TargetError{Target: source, Field: field, Value: value, Reason: strings.Join(errs, ", ")}
+	}
+	return nil
+}
+
+// validatePort validates a port number or name. An empty port is valid, the
+// port is then selected by the caller.
+func validatePort(source, port string) error {
+	if port == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(port); err == nil {
+		if errs := validation.IsValidPortNum(n); len(errs) != 0 {
+			return &TargetError{Target: source, Field: "port", Value: port, Reason: strings.Join(errs, ", ")}
+		}
+		return nil
+	}
+	return validateName(source, "port", port, validation.IsValidPortName)
+}
+
+// splitNamespace splits a name of the form NAME.NAMESPACE.
+func splitNamespace(s string) (name, namespace string) {
+	if i := strings.Index(s, "."); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+func joinPath(segments []string) string {
+	if len(segments) == 0 {
+		return ""
 	}
+	return "/" + strings.Join(segments, "/")
 }
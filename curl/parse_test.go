@@ -1,7 +1,10 @@
 package curl
 
 import (
+	"errors"
+	"net"
 	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -15,6 +18,16 @@ var resourceTypeMap = map[string]string{
 	"sts":          "statefulset",
 	"statefulset":  "statefulset",
 	"statefulsets": "statefulset",
+	"svc":          "service",
+	"service":      "service",
+	"services":     "service",
+	"jobs":         "job",
+	"pods":         "pod",
+}
+
+func lookupResourceType(resourceType string) (string, bool) {
+	canonicalType, ok := resourceTypeMap[strings.ToLower(resourceType)]
+	return canonicalType, ok
 }
 
 func TestParseResourceTarget(t *testing.T) {
@@ -66,6 +79,186 @@ func TestParseResourceTarget(t *testing.T) {
 				NewPath:      "/",
 			},
 		},
+		{
+			name:   "service abbreviation as host, name:port as path",
+			urlStr: "http://svc/api:http/healthz",
+			want: ResourceTarget{
+				IsResource:   true,
+				ResourceType: "service",
+				ResourceName: "api",
+				PodPort:      "http",
+				NewPath:      "/healthz",
+			},
+		},
+		{
+			name:   "daemonset with node as host, name@node:port as path",
+			urlStr: "http://ds/node-exporter@node-1:9100/metrics",
+			want: ResourceTarget{
+				IsResource:   true,
+				ResourceType: "daemonset",
+				ResourceName: "node-exporter",
+				NodeName:     "node-1",
+				PodPort:      "9100",
+				NewPath:      "/metrics",
+			},
+		},
+		{
+			name:   "service with namespace and context, name.namespace@@context:port as path",
+			urlStr: "http://svc/api.payments@@prod:http/healthz",
+			want: ResourceTarget{
+				IsResource:   true,
+				ResourceType: "service",
+				ResourceName: "api",
+				Namespace:    "payments",
+				Context:      "prod",
+				PodPort:      "http",
+				NewPath:      "/healthz",
+			},
+		},
+		{
+			name:   "namespaces as host, namespace/type/name:port as path",
+			urlStr: "http://namespaces/payments/deploy/api:8080/v1/status",
+			want: ResourceTarget{
+				IsResource:   true,
+				ResourceType: "deployment",
+				ResourceName: "api",
+				Namespace:    "payments",
+				PodPort:      "8080",
+				NewPath:      "/v1/status",
+			},
+		},
+		{
+			name:   "ns as host, dotted name, node and context",
+			urlStr: "ns/payments/ds/agent.v2@node-1@prod",
+			want: ResourceTarget{
+				IsResource:   true,
+				ResourceType: "daemonset",
+				ResourceName: "agent.v2",
+				Namespace:    "payments",
+				NodeName:     "node-1",
+				Context:      "prod",
+				NewPath:      "",
+			},
+		},
+		{
+			name:   "pod with port, resource type as first path segment",
+			urlStr: "http://my-api:8080/jobs/42",
+			want: ResourceTarget{
+				PodName: "my-api",
+				PodPort: "8080",
+				NewPath: "/jobs/42",
+			},
+		},
+		{
+			name:   "pod, resource type as first path segment",
+			urlStr: "http://my-api/jobs/42",
+			want: ResourceTarget{
+				PodName: "my-api",
+				NewPath: "/jobs/42",
+			},
+		},
+		{
+			name:   "pod, pods as first path segment",
+			urlStr: "http://mypod/pods/x/y",
+			want: ResourceTarget{
+				PodName: "mypod",
+				NewPath: "/pods/x/y",
+			},
+		},
+		{
+			name:   "podname.namespace:port",
+			urlStr: "http://mypod.payments:8080/path",
+			want: ResourceTarget{
+				IsResource: false,
+				PodName:    "mypod",
+				Namespace:  "payments",
+				PodPort:    "8080",
+				NewPath:    "/path",
+			},
+		},
+		{
+			name:   "service DNS name",
+			urlStr: "http://api.payments.svc.cluster.local:8080/x",
+			want: ResourceTarget{
+				IsResource:   true,
+				ResourceType: "service",
+				ResourceName: "api",
+				Namespace:    "payments",
+				PodPort:      "8080",
+				NewPath:      "/x",
+			},
+		},
+		{
+			name:   "statefulset pod DNS name",
+			urlStr: "http://web-0.web.db.svc/",
+			want: ResourceTarget{
+				IsResource: false,
+				PodName:    "web-0",
+				Namespace:  "db",
+				NewPath:    "/",
+			},
+		},
+		{
+			name:   "pod IPv4 address",
+			urlStr: "http://10.1.2.3:8080/metrics",
+			want: ResourceTarget{
+				IsResource: false,
+				PodIP:      "10.1.2.3",
+				PodPort:    "8080",
+				NewPath:    "/metrics",
+			},
+		},
+		{
+			name:   "pod IPv6 address",
+			urlStr: "http://[fd00::1:2]:8080/metrics",
+			want: ResourceTarget{
+				IsResource: false,
+				PodIP:      "fd00::1:2",
+				PodPort:    "8080",
+				NewPath:    "/metrics",
+			},
+		},
+		{
+			name:   "pod DNS name",
+			urlStr: "10-1-2-3.payments.pod.cluster.local",
+			want: ResourceTarget{
+				IsResource: false,
+				PodIP:      "10.1.2.3",
+				Namespace:  "payments",
+				NewPath:    "",
+			},
+		},
+		{
+			name:   "deployment with container and port",
+			urlStr: "http://deploy/api:envoy:admin/stats",
+			want: ResourceTarget{
+				IsResource:    true,
+				ResourceType:  "deployment",
+				ResourceName:  "api",
+				ContainerName: "envoy",
+				PodPort:       "admin",
+				NewPath:       "/stats",
+			},
+		},
+		{
+			name:   "pods selected by label, host omitted",
+			urlStr: "http:///metrics",
+			want: ResourceTarget{
+				IsResource: false,
+				PodName:    "",
+				NewPath:    "/metrics",
+			},
+		},
+		{
+			name:   "pods selected by label, host set to _",
+			urlStr: "http://_:8080/metrics",
+			want: ResourceTarget{
+				IsResource: false,
+				PodName:    "_",
+				PodPort:    "8080",
+				NewPath:    "/metrics",
+			},
+		},
 		{
 			name:   "type/name:port in host",
 			urlStr: "http://deployment/mydeploy:3000",
@@ -138,15 +331,120 @@ func TestParseResourceTarget(t *testing.T) {
 			if err != nil {
 				t.Fatalf("url.Parse failed: %v", err)
 			}
-			got := ParseResourceTarget(u, resourceTypeMap)
+			got, err := ParseResourceTarget(u, lookupResourceType)
+			if err != nil {
+				t.Fatalf("ParseResourceTarget(%q) failed: %v", tt.urlStr, err)
+			}
 			if got.IsResource != tt.want.IsResource ||
 				got.ResourceType != tt.want.ResourceType ||
 				got.ResourceName != tt.want.ResourceName ||
+				got.NodeName != tt.want.NodeName ||
+				got.Namespace != tt.want.Namespace ||
+				got.Context != tt.want.Context ||
 				got.PodName != tt.want.PodName ||
+				got.PodIP != tt.want.PodIP ||
 				got.PodPort != tt.want.PodPort ||
+				got.ContainerName != tt.want.ContainerName ||
 				got.NewPath != tt.want.NewPath {
 				t.Errorf("ParseResourceTarget(%q) = %+v, want %+v", tt.urlStr, got, tt.want)
 			}
 		})
 	}
 }
+
+func TestParseResourceTargetErrors(t *testing.T) {
+	tests := []struct {
+		urlStr string
+		field  string
+	}{
+		{urlStr: "http://MyPod/", field: "pod name"},
+		{urlStr: "http://mypod:99999/", field: "port"},
+		{urlStr: "http://deploy/api:not_a_port/", field: "port"},
+		{urlStr: "http://deploy/api:Envoy:http/", field: "container name"},
+		{urlStr: "http://deploy/api.not_a_namespace/", field: "namespace"},
+		{urlStr: "http://deploy/:8080/", field: "name"},
+		{urlStr: "http://ds/agent@/", field: "node name"},
+		{urlStr: "http://ds/agent@node@/", field: "context"},
+		{urlStr: "http://ds/agent@node@ctx@more/", field: "name"},
+		{urlStr: "http://ns/Payments/deploy/api/", field: "namespace"},
+		{urlStr: "http://ns/payments/deploy/", field: "name"},
+		{urlStr: "http://ns/payments/", field: "path"},
+		{urlStr: "http://namespaces/payments/widgets/api/", field: "resource type"},
+		{urlStr: "http://1-2-3.payments.pod/", field: "pod IP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.urlStr, func(t *testing.T) {
+			u, err := url.Parse(tt.urlStr)
+			if err != nil {
+				t.Fatalf("url.Parse failed: %v", err)
+			}
+			_, err = ParseResourceTarget(u, lookupResourceType)
+			var targetErr *TargetError
+			if !errors.As(err, &targetErr) {
+				t.Fatalf("ParseResourceTarget(%q): expected a *TargetError, got %v", tt.urlStr, err)
+			}
+			if targetErr.Field != tt.field {
+				t.Errorf("ParseResourceTarget(%q): invalid %s, want invalid %s", tt.urlStr, targetErr.Field, tt.field)
+			}
+		})
+	}
+}
+
+func FuzzParseResourceTarget(f *testing.F) {
+	for _, seed := range []string{
+		"http://mypod:8080/path",
+		"http://deployment/mydeploy:3000/foo",
+		"http://svc/api.payments@@prod:http/healthz",
+		"http://ns/payments/deploy/api:envoy:8080/v1/status",
+		"http://my-api:8080/jobs/42",
+		"http://ds/node-exporter@node-1:9100/metrics",
+		"http://api.payments.svc.cluster.local:8080/x",
+		"http://web-0.web.db.svc/",
+		"http://[fd00::1:2]:8080/metrics",
+		"10-1-2-3.payments.pod.cluster.local",
+		"http://_:8080/metrics",
+		"ds/myds",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, urlStr string) {
+		u, err := url.Parse(urlStr)
+		if err != nil {
+			return
+		}
+
+		target, err := ParseResourceTarget(u, lookupResourceType)
+		if err != nil {
+			var targetErr *TargetError
+			if !errors.As(err, &targetErr) {
+				t.Fatalf("ParseResourceTarget(%q): unexpected error type %T", urlStr, err)
+			}
+			return
+		}
+
+		switch {
+		case target.IsResource:
+			if target.ResourceType == "" || target.ResourceName == "" {
+				t.Errorf("ParseResourceTarget(%q) = %+v: resource target without type or name", urlStr, target)
+			}
+			if target.PodName != "" || target.PodIP != "" {
+				t.Errorf("ParseResourceTarget(%q) = %+v: resource target with a pod name or IP", urlStr, target)
+			}
+		case target.PodIP != "":
+			if net.ParseIP(target.PodIP) == nil {
+				t.Errorf("ParseResourceTarget(%q) = %+v: invalid pod IP", urlStr, target)
+			}
+		}
+
+		if strings.ContainsAny(target.ResourceName+target.PodName+target.Namespace+target.NodeName+target.ContainerName, "/:@") {
+			t.Errorf("ParseResourceTarget(%q) = %+v: separators left in names", urlStr, target)
+		}
+		if target.PodPort != "" {
+			if err := validatePort(urlStr, target.PodPort); err != nil {
+				t.Errorf("ParseResourceTarget(%q) = %+v: %v", urlStr, target, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/segmentio/kubectl-curl/curl"
+)
+
+func TestRequestHost(t *testing.T) {
+	tests := []struct {
+		url       string
+		target    curl.ResourceTarget
+		isService bool
+		host      string
+	}{
+		{
+			url:    "http://mypod:8080/",
+			target: curl.ResourceTarget{PodName: "mypod", PodPort: "8080"},
+			host:   "mypod:8080",
+		},
+		{
+			url:    "http://mypod.other/",
+			target: curl.ResourceTarget{PodName: "mypod", Namespace: "other"},
+			host:   "mypod.other",
+		},
+		{
+			url:       "https://svc/myservice:https",
+			target:    curl.ResourceTarget{IsResource: true, ResourceType: "services", ResourceName: "myservice", PodPort: "https"},
+			isService: true,
+			host:      "myservice.default.svc",
+		},
+		{
+			url:       "https://myservice.other.svc.cluster.local:8443/",
+			target:    curl.ResourceTarget{IsResource: true, ResourceType: "services", ResourceName: "myservice", Namespace: "other", PodPort: "8443"},
+			isService: true,
+			host:      "myservice.other.svc.cluster.local:8443",
+		},
+		{
+			url:    "http://deploy/mydeployment:8080",
+			target: curl.ResourceTarget{IsResource: true, ResourceType: "deployments", ResourceName: "mydeployment", PodPort: "8080"},
+			host:   "localhost:8080",
+		},
+		{
+			url:    "http://_/metrics",
+			target: curl.ResourceTarget{PodName: "_"},
+			host:   "localhost",
+		},
+		{
+			url:    "http://[fd00::1]/",
+			target: curl.ResourceTarget{PodIP: "fd00::1"},
+			host:   "[fd00::1]",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			u, err := url.Parse(test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if host := requestHost(u, test.target, "default", test.isService); host != test.host {
+				t.Errorf("host mismatch: want=%q got=%q", test.host, host)
+			}
+		})
+	}
+}
+
+func TestResolveTargetContext(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: prod
+  cluster: {server: "https://prod.example.com"}
+contexts:
+- name: prod
+  context: {cluster: prod}
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func(path *string) { config.KubeConfig = path }(config.KubeConfig)
+	config.KubeConfig = &kubeconfig
+
+	// A single @ is a node even when a context has the same name.
+	target := curl.ResourceTarget{IsResource: true, ResourceType: "daemonsets", ResourceName: "agent", NodeName: "prod"}
+	if err := resolveTargetContext(&target); err != nil {
+		t.Fatal(err)
+	}
+	if target.NodeName != "prod" || target.Context != "" {
+		t.Errorf("the node was changed to a context: %+v", target)
+	}
+
+	target = curl.ResourceTarget{IsResource: true, ResourceType: "services", ResourceName: "api", Context: "prod"}
+	if err := resolveTargetContext(&target); err != nil {
+		t.Error(err)
+	}
+
+	target = curl.ResourceTarget{IsResource: true, ResourceType: "services", ResourceName: "api", Context: "staging"}
+	if err := resolveTargetContext(&target); err == nil || err.Error() != "context staging not found in kubeconfig" {
+		t.Errorf("expected an error for a context which does not exist, got %v", err)
+	}
+}
+
+func TestSelectsPods(t *testing.T) {
+	tests := []struct {
+		scenario      string
+		target        curl.ResourceTarget
+		labelSelector string
+		fieldSelector string
+		isSelector    bool
+		err           string
+	}{
+		{
+			scenario:      "omitted host with a label selector",
+			labelSelector: "app=web",
+			isSelector:    true,
+		},
+		{
+			scenario:      "_ host with a field selector",
+			target:        curl.ResourceTarget{PodName: "_"},
+			fieldSelector: "spec.nodeName=node-a",
+			isSelector:    true,
+		},
+		{
+			scenario:      "_ host with label and field selectors",
+			target:        curl.ResourceTarget{PodName: "_"},
+			labelSelector: "app=web",
+			fieldSelector: "spec.nodeName=node-a",
+			isSelector:    true,
+		},
+		{
+			scenario: "named pod",
+			target:   curl.ResourceTarget{PodName: "mypod"},
+		},
+		{
+			scenario: "resource",
+			target:   curl.ResourceTarget{IsResource: true, ResourceType: "deployments", ResourceName: "mydeployment"},
+		},
+		{
+			scenario: "omitted host without selectors",
+			err:      "missing pod name in URL, either set one or select pods with -l/--selector or --field-selector",
+		},
+		{
+			scenario: "_ host without selectors",
+			target:   curl.ResourceTarget{PodName: "_"},
+			err:      "missing pod name in URL, either set one or select pods with -l/--selector or --field-selector",
+		},
+		{
+			scenario:      "named pod with a label selector",
+			target:        curl.ResourceTarget{PodName: "mypod"},
+			labelSelector: "app=web",
+			err:           "-l/--selector and --field-selector require the URL host to be omitted or set to _",
+		},
+		{
+			scenario:      "resource with a field selector",
+			target:        curl.ResourceTarget{IsResource: true, ResourceType: "deployments", ResourceName: "_"},
+			fieldSelector: "spec.nodeName=node-a",
+			err:           "-l/--selector and --field-selector require the URL host to be omitted or set to _",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			isSelector, err := selectsPods(test.target, test.labelSelector, test.fieldSelector)
+			if test.err != "" {
+				if e, ok := err.(usageError); !ok || string(e) != test.err {
+					t.Fatalf("error mismatch: want=%q got=%v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isSelector != test.isSelector {
+				t.Errorf("selector mismatch: want=%t got=%t", test.isSelector, isSelector)
+			}
+		})
+	}
+}
+
+func TestPrettyArgsRedactsSecrets(t *testing.T) {
+	args := []string{"--oauth2-bearer", "secret-token", "--header", "X-Test: yes", "--header", "Authorization: Basic dXNlcg==", "--userinfo", "user:pass", "http://localhost/"}
+	want := `--oauth2-bearer <redacted> --header "X-Test: yes" --header "Authorization: <redacted>" --userinfo <redacted> http://localhost/`
+	if got := prettyArgs(args); got != want {
+		t.Errorf("arguments mismatch:\nwant: %s\ngot:  %s", want, got)
+	}
+}
+
+func TestCurlOptionName(t *testing.T) {
+	for flagName, option := range map[string]string{
+		"userinfo":        "--user",
+		"curl-local-port": "--local-port",
+		"header":          "--header",
+	} {
+		if name := curlOptionName(flagName); name != option {
+			t.Errorf("%s: option mismatch: want=%s got=%s", flagName, option, name)
+		}
+	}
+}
+
+func TestConnectTo(t *testing.T) {
+	tests := []struct {
+		host, address string
+		value         string
+	}{
+		{host: "myservice.default.svc", address: "localhost", value: "myservice.default.svc::localhost:8080"},
+		{host: "fd00::1", address: "0.0.0.0", value: "[fd00::1]::127.0.0.1:8080"},
+		{host: "mypod", address: "::", value: "mypod::[::1]:8080"},
+	}
+	for _, test := range tests {
+		if value := connectTo(test.host, test.address, 8080); value != test.value {
+			t.Errorf("value mismatch: want=%s got=%s", test.value, value)
+		}
+	}
+}
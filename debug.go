@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+
+	"github.com/segmentio/kubectl-curl/curl"
+)
+
+// defaultDebugImage is the image of the ephemeral containers that requests are
+// sent from with --via debug.
+const defaultDebugImage = "docker.io/curlimages/curl:8.10.1"
+
+// ephemeralContainerTimeout is how long to wait for an ephemeral container to
+// start, which includes pulling its image.
+const ephemeralContainerTimeout = 2 * time.Minute
+
+// failedWaitingReasons are the reasons of waiting containers which will not
+// start without a change of their spec.
+var failedWaitingReasons = map[string]bool{
+	"ErrImagePull":         true,
+	"ImagePullBackOff":     true,
+	"InvalidImageName":     true,
+	"CreateContainerError": true,
+}
+
+// curlDebug runs curl in an ephemeral container added to the pod through the
+// ephemeralcontainers subresource. The ephemeral container shares the network
+// namespace of the pod, so like --via exec it reaches the servers which only
+// listen on 127.0.0.1, without requiring curl in the image of the pod.
+//
+// Ephemeral containers cannot be removed from pods, a summary of the container
+// which was left behind is always printed.
+func curlDebug(ctx context.Context, req curlRequest, pod *corev1.Pod, containerName string, connectPort int32) error {
+	if containerName == "" {
+		containerName = defaultContainer(pod)
+	}
+
+	c, err := newRemoteCurl(req, connectPort)
+	if err != nil {
+		return fmt.Errorf("unable to run curl in an ephemeral container of pod/%s: %w", pod.Name, err)
+	}
+	defer c.close()
+
+	name := "kubectl-curl-" + utilrand.String(5)
+	pod = pod.DeepCopy()
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, ephemeralCurlContainer(name, containerName, c))
+
+	log.Printf("adding ephemeral container %s to pod/%s: %s", name, pod.Name, prettyArgs(c.argv))
+	if _, err := req.client.CoreV1().Pods(pod.Namespace).UpdateEphemeralContainers(ctx, pod.Name, pod, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to add an ephemeral container to pod/%s: %w", pod.Name, err)
+	}
+	if debug || isVerbose(req.args) {
+		_, _ = fmt.Fprintf(req.stderr, "Running curl in ephemeral container %s of pod/%s (image %s, target %s)\n", name, pod.Name, debugImage, containerName)
+	}
+
+	var state corev1.ContainerState
+	defer func() {
+		_, _ = fmt.Fprintf(req.stderr, "* Left ephemeral container %s in pod/%s (image %s, %s), ephemeral containers are only removed with the pod\n",
+			name, pod.Name, debugImage, describeContainerState(state))
+	}()
+
+	if state, err = waitEphemeralContainer(ctx, req, pod, name, false); err != nil {
+		return err
+	}
+	if err := startError(state); err != nil {
+		return err
+	}
+
+	if c.stdin != nil {
+		// The container waits for its standard input, the options of curl
+		// or the data of @- values, which is only sent to the first
+		// attached client.
+		executor, err := curl.NewAttachExecutor(req.config, pod.Namespace, pod.Name, &corev1.PodAttachOptions{
+			Container: name,
+			Stdin:     true,
+			Stdout:    true,
+			Stderr:    true,
+		}, transport)
+		if err != nil {
+			return err
+		}
+		err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+			Stdin:  c.stdin,
+			Stdout: c.stdout,
+			Stderr: req.stderr,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to attach to ephemeral container %s of pod/%s: %w", name, pod.Name, err)
+		}
+	} else {
+		// The logs of the container are read from the start, so the output
+		// written before the container was seen running is not lost. They
+		// interleave the standard output and error of curl.
+		logs, err := req.client.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+			Container: name,
+			Follow:    true,
+		}).Stream(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read the output of ephemeral container %s of pod/%s: %w", name, pod.Name, err)
+		}
+		_, err = io.Copy(c.stdout, logs)
+		logs.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if state, err = waitEphemeralContainer(ctx, req, pod, name, true); err != nil {
+		return err
+	}
+	if err := startError(state); err != nil {
+		return err
+	}
+	if terminated := state.Terminated; terminated.ExitCode != 0 {
+		return utilexec.CodeExitError{
+			Err:  fmt.Errorf("command terminated with exit code %d", terminated.ExitCode),
+			Code: int(terminated.ExitCode),
+		}
+	}
+	return nil
+}
+
+// ephemeralCurlContainer returns the ephemeral container running the curl
+// command c, targeting the container of the pod named containerName.
+//
+// The spec of ephemeral containers stays in the pod, so the options of curl,
+// which may contain credentials, are only sent on the standard input of the
+// container, see newRemoteCurl.
+func ephemeralCurlContainer(name, containerName string, c *remoteCurl) corev1.EphemeralContainer {
+	return corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:                     name,
+			Image:                    debugImage,
+			Command:                  c.argv,
+			Stdin:                    c.stdin != nil,
+			StdinOnce:                c.stdin != nil,
+			TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+		},
+		TargetContainerName: containerName,
+	}
+}
+
+// waitEphemeralContainer waits for the ephemeral container of the pod to be
+// running, or terminated if terminated is true, and returns its state.
+func waitEphemeralContainer(ctx context.Context, req curlRequest, pod *corev1.Pod, name string, terminated bool) (corev1.ContainerState, error) {
+	var state corev1.ContainerState
+	err := wait.PollUntilContextTimeout(ctx, 500*time.Millisecond, ephemeralContainerTimeout, true, func(ctx context.Context) (bool, error) {
+		p, err := req.client.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, status := range p.Status.EphemeralContainerStatuses {
+			if status.Name != name {
+				continue
+			}
+			state = status.State
+			switch {
+			case state.Terminated != nil:
+				return true, nil
+			case state.Running != nil:
+				return !terminated, nil
+			case state.Waiting != nil && failedWaitingReasons[state.Waiting.Reason]:
+				return false, fmt.Errorf("ephemeral container %s of pod/%s failed to start: %s: %s", name, pod.Name, state.Waiting.Reason, state.Waiting.Message)
+			}
+		}
+		return false, nil
+	})
+	if wait.Interrupted(err) && ctx.Err() == nil {
+		event := "start"
+		if terminated {
+			event = "exit"
+		}
+		err = fmt.Errorf("timed out waiting for ephemeral container %s of pod/%s to %s", name, pod.Name, event)
+	}
+	return state, err
+}
+
+// startError returns an error if the container runtime failed to run curl in
+// the container, for example when its image has no curl binary.
+func startError(state corev1.ContainerState) error {
+	if terminated := state.Terminated; terminated != nil && (terminated.Reason == "StartError" || terminated.Reason == "ContainerCannotRun") {
+		return fmt.Errorf("image %s cannot run curl, use --debug-image to select another one: %s", debugImage, strings.TrimSpace(terminated.Message))
+	}
+	return nil
+}
+
+// describeContainerState returns a short description of the state of a
+// container.
+func describeContainerState(state corev1.ContainerState) string {
+	switch {
+	case state.Terminated != nil:
+		return fmt.Sprintf("exited with code %d", state.Terminated.ExitCode)
+	case state.Running != nil:
+		return "running"
+	case state.Waiting != nil && state.Waiting.Reason != "":
+		return "waiting: " + state.Waiting.Reason
+	}
+	return "not started"
+}
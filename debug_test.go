@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestDescribeContainerState(t *testing.T) {
+	tests := []struct {
+		state corev1.ContainerState
+		want  string
+	}{
+		{corev1.ContainerState{}, "not started"},
+		{corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}, "waiting: ImagePullBackOff"},
+		{corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}, "running"},
+		{corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 22}}, "exited with code 22"},
+	}
+	for _, test := range tests {
+		if got := describeContainerState(test.state); got != test.want {
+			t.Errorf("%+v: got %q, want %q", test.state, got, test.want)
+		}
+	}
+}
+
+func TestStartError(t *testing.T) {
+	if err := startError(corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 22, Reason: "Error"}}); err != nil {
+		t.Errorf("unexpected error for a curl failure: %v", err)
+	}
+	state := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+		ExitCode: 128,
+		Reason:   "StartError",
+		Message:  `exec: "curl": executable file not found in $PATH`,
+	}}
+	if err := startError(state); err == nil {
+		t.Error("expected an error for a container which could not run curl")
+	}
+}
+
+func TestEphemeralCurlContainer(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(config, []byte("header = \"X-Api-Key: s3cr3t\"\noauth2-bearer = \"t0ken\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	req := curlRequest{
+		args:       []string{"--config", config, "--data-binary", "@" + config},
+		requestURL: url.URL{Scheme: "http", Host: "10.0.0.1:9901", Path: "/ready"},
+	}
+	c, err := newRemoteCurl(req, 9901)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+
+	container := ephemeralCurlContainer("kubectl-curl-abcde", "app", c)
+	if !container.Stdin || !container.StdinOnce || container.TargetContainerName != "app" {
+		t.Errorf("the options are not sent on the standard input of the container: %+v", container)
+	}
+	for _, arg := range container.Command {
+		if strings.Contains(arg, "s3cr3t") || strings.Contains(arg, "t0ken") {
+			t.Errorf("credentials stored in the spec of the ephemeral container: %q", container.Command)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+
+	"github.com/segmentio/kubectl-curl/curl"
+)
+
+// localFileOptions are the curl options which read or write local files that
+// the curl command ran in a container has no access to.
+var localFileOptions = map[string]bool{
+	"--cacert":      true,
+	"--capath":      true,
+	"--cert":        true,
+	"--key":         true,
+	"--cookie-jar":  true,
+	"--upload-file": true,
+	"--dump-header": true,
+	"--trace":       true,
+	"--trace-ascii": true,
+	"--netrc-file":  true,
+	"--stderr":      true,
+	"--output-dir":  true,
+}
+
+// remoteCurlArgs translates curl arguments to the arguments of a curl command
+// ran in a container. The content of the local files read by curl (config
+// files, data, headers, and write-out formats) is inlined in the arguments,
+// and the file of --output is returned to be written locally. Values of @-
+// are kept since the standard input is streamed to the container.
+func remoteCurlArgs(args []string) (remoteArgs []string, output string, err error) {
+	remoteArgs = make([]string, 0, len(args))
+
+	for i := 0; i < len(args); i++ {
+		name := args[i]
+		f := lookupCurlFlag(name)
+		if f == nil || f.NoOptDefVal != "" {
+			remoteArgs = append(remoteArgs, name)
+			continue
+		}
+		if i++; i == len(args) {
+			return nil, "", fmt.Errorf("option %s requires a value", name)
+		}
+		value := args[i]
+
+		switch {
+		case localFileOptions[name]:
+			return nil, "", fmt.Errorf("option %s uses a local file, which the container has no access to", name)
+
+		case name == "--cookie" && !strings.Contains(value, "="):
+			return nil, "", fmt.Errorf("option %s uses a local file, which the container has no access to", name)
+
+		case name == "--output":
+			output = value
+
+		case name == "--config" && value != "-":
+			b, err := os.ReadFile(value)
+			if err != nil {
+				return nil, "", err
+			}
+			options, err := parseCurlConfig(b)
+			if err != nil {
+				return nil, "", err
+			}
+			configArgs := make([]string, 0, 2*len(options))
+			for _, opt := range options {
+				configArgs = append(configArgs, opt.name)
+				if lookupCurlFlag(opt.name).NoOptDefVal == "" {
+					configArgs = append(configArgs, opt.value)
+				}
+			}
+			translated, configOutput, err := remoteCurlArgs(configArgs)
+			if err != nil {
+				return nil, "", err
+			}
+			if configOutput != "" {
+				output = configOutput
+			}
+			remoteArgs = append(remoteArgs, translated...)
+
+		case (name == "--header" || name == "--write-out") && strings.HasPrefix(value, "@") && value != "@-":
+			b, err := os.ReadFile(value[1:])
+			if err != nil {
+				return nil, "", err
+			}
+			if name == "--write-out" {
+				remoteArgs = append(remoteArgs, name, string(b))
+				break
+			}
+			for _, line := range strings.Split(string(b), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					remoteArgs = append(remoteArgs, name, line)
+				}
+			}
+
+		case strings.HasPrefix(name, "--data") && readsLocalFile(name, value):
+			b, err := readCurlData(name, value, nil)
+			if err != nil {
+				return nil, "", err
+			}
+			if bytes.IndexByte(b, 0) >= 0 {
+				return nil, "", fmt.Errorf("option %s sends binary data, which cannot be passed to a command in a container", name)
+			}
+			remoteArgs = append(remoteArgs, "--data-raw", string(b))
+
+		default:
+			remoteArgs = append(remoteArgs, name, value)
+		}
+	}
+
+	return remoteArgs, output, nil
+}
+
+// readsLocalFile returns true if the value of a --data option is read from a
+// local file.
+func readsLocalFile(name, value string) bool {
+	switch name {
+	case "--data-raw":
+		return false
+	case "--data-urlencode":
+		if _, _, hasKey := strings.Cut(value, "="); hasKey {
+			return false
+		}
+		_, path, ok := strings.Cut(value, "@")
+		return ok && path != "-"
+	}
+	return strings.HasPrefix(value, "@") && value != "@-"
+}
+
+// readsStdin returns true if the curl arguments read the standard input.
+func readsStdin(args []string) bool {
+	for i, arg := range args {
+		if strings.HasSuffix(arg, "@-") || (arg == "-" && i > 0 && args[i-1] == "--config") {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteCurlConfig returns the curl config file passing the options of args,
+// as translated by remoteCurlArgs.
+func remoteCurlConfig(args []string) string {
+	config := new(strings.Builder)
+	for i := 0; i < len(args); i++ {
+		name := args[i]
+		if f := lookupCurlFlag(name); f == nil || f.NoOptDefVal != "" || i+1 == len(args) {
+			fmt.Fprintf(config, "%s\n", strings.TrimPrefix(name, "--"))
+			continue
+		}
+		i++
+		fmt.Fprintf(config, "%s = %s\n", strings.TrimPrefix(name, "--"), curlConfigQuote(args[i]))
+	}
+	return config.String()
+}
+
+// commandLineSecret returns the first option of args whose value must not be
+// passed in the command line of a container: the credentials, and the config
+// files which may contain some, like the one of secretArgs.
+func commandLineSecret(args []string) string {
+	for i, arg := range args {
+		if sensitiveArgs[arg] || (arg == "--config" && i+1 < len(args) && args[i+1] != "-") {
+			return arg
+		}
+	}
+	return ""
+}
+
+// remoteCurl is a curl command ran in a container of a pod, with the streams
+// it is attached to.
+type remoteCurl struct {
+	argv   []string
+	stdin  io.Reader
+	stdout io.Writer
+	close  func() error
+}
+
+// newRemoteCurl returns the curl command sending the request of req from a
+// container. When connectPort is not zero, the connections are sent to this
+// port on the loopback interface, otherwise to the host of the URL. The close
+// function of the returned command must be called once it exited.
+//
+// The options are sent to curl as a config file on its standard input, so
+// credentials never appear in the command, which is recorded by the audit
+// logs of the API server and visible to the processes of the container. When
+// the standard input is streamed to the container for @- values, the options
+// are passed in the command instead, and credentials are rejected.
+func newRemoteCurl(req curlRequest, connectPort int32) (*remoteCurl, error) {
+	requestURL := req.requestURL
+
+	remoteArgs, output, err := remoteCurlArgs(req.args)
+	if err != nil {
+		return nil, err
+	}
+
+	argv := []string{"curl"}
+	c := &remoteCurl{stdout: req.stdout, close: func() error { return nil }}
+	if readsStdin(remoteArgs) {
+		if name := commandLineSecret(req.args); name != "" {
+			return nil, fmt.Errorf("option %s cannot be combined with @-, the standard input is sent to the container and the option would be passed in the command line of curl", name)
+		}
+		argv = append(argv, remoteArgs...)
+		c.stdin = req.stdin
+	} else {
+		argv = append(argv, "--config", "-")
+		c.stdin = strings.NewReader(remoteCurlConfig(remoteArgs))
+	}
+	if connectPort != 0 {
+		argv = append(argv, "--connect-to", connectTo(requestURL.Hostname(), "127.0.0.1", connectPort))
+	}
+	c.argv = append(argv, requestURL.String(), "--silent")
+
+	if output != "" && output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			return nil, err
+		}
+		c.stdout, c.close = f, f.Close
+	}
+	return c, nil
+}
+
+// curlExec runs curl in a container of the pod through the exec subresource,
+// connecting to connectPort on the loopback interface, see newRemoteCurl.
+// Unlike the port-forward, this reaches the servers which only listen on
+// 127.0.0.1.
+func curlExec(ctx context.Context, req curlRequest, pod *corev1.Pod, containerName string, connectPort int32) error {
+	if containerName == "" {
+		containerName = defaultContainer(pod)
+	}
+
+	c, err := newRemoteCurl(req, connectPort)
+	if err != nil {
+		return fmt.Errorf("unable to run curl in container %s of pod/%s: %w", containerName, pod.Name, err)
+	}
+	defer c.close()
+
+	if debug || isVerbose(req.args) {
+		_, _ = fmt.Fprintf(req.stderr, "Running curl in container %s of pod/%s\n", containerName, pod.Name)
+	}
+	log.Printf("kubectl exec -n %s %s -c %s -- %s", pod.Namespace, pod.Name, containerName, prettyArgs(c.argv))
+
+	executor, err := curl.NewExecExecutor(req.config, pod.Namespace, pod.Name, &corev1.PodExecOptions{
+		Container: containerName,
+		Command:   c.argv,
+		Stdin:     c.stdin != nil,
+		Stdout:    true,
+		Stderr:    true,
+	}, transport)
+	if err != nil {
+		return err
+	}
+
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  c.stdin,
+		Stdout: c.stdout,
+		Stderr: req.stderr,
+	})
+	if isMissingExecutable(err) {
+		return fmt.Errorf("container %s of pod/%s has no curl binary, use --via debug to run curl from an ephemeral container instead: %w", containerName, pod.Name, err)
+	}
+	return err
+}
+
+// isMissingExecutable returns true if err reports that the command ran in a
+// container was not found, either by the container runtime or by a shell.
+func isMissingExecutable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitStatus() == 127 {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "executable file not found") || strings.Contains(msg, "no such file or directory")
+}
+
+// defaultContainer returns the container that commands are ran in when none
+// was selected, like kubectl exec does.
+func defaultContainer(pod *corev1.Pod) string {
+	if name := pod.Annotations[defaultContainerAnnotation]; name != "" {
+		return name
+	}
+	return pod.Spec.Containers[0].Name
+}
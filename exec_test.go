@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRemoteCurlArgs(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	config := write("config", "header = \"X-Api-Key: s3cr3t\"\nuser = \"admin:pa\\\"ss\"\nfail\n")
+	data := write("data.json", "{\n  \"a\": 1\n}\n")
+	headers := write("headers", "X-One: 1\n\nX-Two: 2\n")
+
+	args := []string{
+		"--verbose",
+		"--config", config,
+		"--data", "@" + data,
+		"--data-urlencode", "q@" + data,
+		"--header", "@" + headers,
+		"--output", filepath.Join(dir, "out.json"),
+		"--max-time", "3",
+	}
+	req := curlRequest{args: args, requestURL: url.URL{Scheme: "http", Host: "10.0.0.1:8080", Path: "/"}}
+
+	c, err := newRemoteCurl(req, 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+
+	// The options, including the credentials of the config file, are only
+	// sent on the standard input of curl.
+	wantArgv := []string{"curl", "--config", "-", "--connect-to", "10.0.0.1::127.0.0.1:8080", "http://10.0.0.1:8080/", "--silent"}
+	if !reflect.DeepEqual(c.argv, wantArgv) {
+		t.Errorf("wrong command:\ngot:  %q\nwant: %q", c.argv, wantArgv)
+	}
+	for _, arg := range c.argv {
+		if strings.Contains(arg, "s3cr3t") || strings.Contains(arg, "admin") {
+			t.Errorf("credentials passed in the command: %q", c.argv)
+		}
+	}
+
+	stdin, err := io.ReadAll(c.stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantConfig := strings.Join([]string{
+		`verbose`,
+		`header = "X-Api-Key: s3cr3t"`,
+		`user = "admin:pa\"ss"`,
+		`fail`,
+		`data-raw = "{  \"a\": 1}"`,
+		`data-raw = "q=%7B%0A++%22a%22%3A+1%0A%7D%0A"`,
+		`header = "X-One: 1"`,
+		`header = "X-Two: 2"`,
+		`max-time = "3"`,
+		``,
+	}, "\n")
+	if string(stdin) != wantConfig {
+		t.Errorf("wrong config:\ngot:  %q\nwant: %q", stdin, wantConfig)
+	}
+	if f, ok := c.stdout.(*os.File); !ok || f.Name() != filepath.Join(dir, "out.json") {
+		t.Errorf("the output is not written to the --output file: %v", c.stdout)
+	}
+}
+
+func TestRemoteCurlStdin(t *testing.T) {
+	requestURL := url.URL{Scheme: "http", Host: "mypod", Path: "/"}
+	stdin := strings.NewReader("body")
+
+	// The options are passed in the command when the standard input is sent
+	// for @- values.
+	c, err := newRemoteCurl(curlRequest{args: []string{"--data-binary", "@-", "--header", "X-One: 1"}, requestURL: requestURL, stdin: stdin}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"curl", "--data-binary", "@-", "--header", "X-One: 1", "http://mypod/", "--silent"}
+	if !reflect.DeepEqual(c.argv, want) || c.stdin != stdin {
+		t.Errorf("wrong command: got %q, want %q", c.argv, want)
+	}
+
+	// Credentials are then rejected.
+	config := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(config, []byte("header = \"X-Api-Key: s3cr3t\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"--data-binary", "@-", "--config", config},
+		{"--data-binary", "@-", "--oauth2-bearer", "s3cr3t"},
+	} {
+		if _, err := newRemoteCurl(curlRequest{args: args, requestURL: requestURL, stdin: stdin}, 0); err == nil || !strings.Contains(err.Error(), "@-") {
+			t.Errorf("%q: expected an error, got %v", args, err)
+		}
+	}
+}
+
+func TestRemoteCurlArgsLocalFiles(t *testing.T) {
+	for _, args := range [][]string{
+		{"--cacert", "ca.crt"},
+		{"--cert", "tls.crt", "--key", "tls.key"},
+		{"--cookie", "cookies.txt"},
+		{"--upload-file", "payload"},
+	} {
+		if _, _, err := remoteCurlArgs(args); err == nil || !strings.Contains(err.Error(), "local file") {
+			t.Errorf("%q: expected a local file error, got %v", args, err)
+		}
+	}
+}
+
+func TestReadsStdin(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"--data", "hello"}, false},
+		{[]string{"--data-binary", "@-"}, true},
+		{[]string{"--data-urlencode", "q@-"}, true},
+		{[]string{"--config", "-"}, true},
+		{[]string{"--output", "-"}, false},
+	}
+	for _, test := range tests {
+		if got := readsStdin(test.args); got != test.want {
+			t.Errorf("%q: got %t, want %t", test.args, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// curlAllPods sends the request to each of the pods with send, which is
+// curlPod outside of tests, running at most concurrency requests at the same
+// time. The output of each request is buffered and printed with a header
+// naming the pod once the request completes, so outputs of concurrent
+// requests are never interleaved.
+//
+// When the request reads the standard input, with @- values or --config -, it
+// is read once and the same content is sent to each pod. Otherwise it is not
+// forwarded to curl.
+func curlAllPods(ctx context.Context, req curlRequest, podNames []string, concurrency int, send func(context.Context, curlRequest) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var stdin []byte
+	if req.stdin != nil && readsStdin(req.args) {
+		b, err := io.ReadAll(req.stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read the standard input: %w", err)
+		}
+		stdin = b
+	}
+
+	var (
+		mutex  sync.Mutex
+		failed int
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, concurrency)
+	)
+
+	for _, podName := range podNames {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		}
+
+		wg.Add(1)
+		go func(podName string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			output := new(bytes.Buffer)
+			podReq := req
+			podReq.podName = podName
+			if port, ok := req.podPorts[podName]; ok {
+				podReq.podPort = port
+			}
+			podReq.stdin = nil
+			if stdin != nil {
+				podReq.stdin = bytes.NewReader(stdin)
+			}
+			podReq.stdout = output
+			podReq.stderr = output
+
+			err := send(ctx, podReq)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			_, _ = fmt.Fprintf(req.stdout, "==> pod/%s <==\n", podName)
+			_, _ = req.stdout.Write(output.Bytes())
+			if output.Len() != 0 && !bytes.HasSuffix(output.Bytes(), []byte("\n")) {
+				_, _ = fmt.Fprintln(req.stdout)
+			}
+			if err != nil {
+				_, _ = fmt.Fprintf(req.stderr, "* ERROR: pod/%s: %s\n", podName, err)
+				failed++
+			}
+		}(podName)
+	}
+
+	wg.Wait()
+
+	if failed != 0 {
+		return fmt.Errorf("request failed on %d of %d pods", failed, len(podNames))
+	}
+	return nil
+}
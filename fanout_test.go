@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCurlAllPods(t *testing.T) {
+	var (
+		mutex             sync.Mutex
+		running, maxCount int
+	)
+	send := func(ctx context.Context, req curlRequest) error {
+		mutex.Lock()
+		running++
+		if running > maxCount {
+			maxCount = running
+		}
+		mutex.Unlock()
+		defer func() {
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		if req.stdin != nil {
+			return errors.New("stdin forwarded to a pod")
+		}
+		fmt.Fprintf(req.stdout, "hello from %s", req.podName)
+		if req.podName == "web-3" {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	req := curlRequest{stdin: strings.NewReader("data"), stdout: stdout, stderr: stderr}
+	podNames := []string{"web-1", "web-2", "web-3", "web-4", "web-5"}
+
+	err := curlAllPods(context.Background(), req, podNames, 2, send)
+	if err == nil || err.Error() != "request failed on 1 of 5 pods" {
+		t.Errorf("wrong error: %v", err)
+	}
+	if maxCount > 2 {
+		t.Errorf("%d requests ran concurrently, the limit is 2", maxCount)
+	}
+	for _, name := range podNames {
+		if want := "==> pod/" + name + " <==\nhello from " + name + "\n"; !strings.Contains(stdout.String(), want) {
+			t.Errorf("missing output of pod/%s:\n%s", name, stdout)
+		}
+	}
+	if want := "* ERROR: pod/web-3: connection refused\n"; stderr.String() != want {
+		t.Errorf("wrong errors: got %q, want %q", stderr, want)
+	}
+}
+
+func TestCurlAllPodsStdin(t *testing.T) {
+	var mutex sync.Mutex
+	bodies := make(map[string]string)
+	send := func(ctx context.Context, req curlRequest) error {
+		body, err := io.ReadAll(req.stdin)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		bodies[req.podName] = string(body)
+		mutex.Unlock()
+		return nil
+	}
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	req := curlRequest{args: []string{"--data-binary", "@-"}, stdin: strings.NewReader("data"), stdout: stdout, stderr: stderr}
+	podNames := []string{"web-1", "web-2", "web-3"}
+
+	if err := curlAllPods(context.Background(), req, podNames, 2, send); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range podNames {
+		if bodies[name] != "data" {
+			t.Errorf("pod/%s: got body %q, want %q", name, bodies[name], "data")
+		}
+	}
+}
+
+func TestCurlAllPodsPorts(t *testing.T) {
+	var mutex sync.Mutex
+	ports := make(map[string]string)
+	send := func(ctx context.Context, req curlRequest) error {
+		mutex.Lock()
+		ports[req.podName] = req.podPort
+		mutex.Unlock()
+		return nil
+	}
+
+	req := curlRequest{
+		podPort:  "8080",
+		podPorts: map[string]string{"web-1": "8080", "web-2": "8081"},
+		stdout:   new(bytes.Buffer),
+		stderr:   new(bytes.Buffer),
+	}
+	if err := curlAllPods(context.Background(), req, []string{"web-1", "web-2"}, 2, send); err != nil {
+		t.Fatal(err)
+	}
+	if ports["web-1"] != "8080" || ports["web-2"] != "8081" {
+		t.Errorf("each pod should get its own port: %v", ports)
+	}
+}
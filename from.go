@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/segmentio/kubectl-curl/curl"
+)
+
+// resolveSourcePod resolves the pod that requests are sent from with --from.
+// The source is parsed and resolved like the host of URLs, for example
+// POD, POD.NAMESPACE, a pod IP, TYPE/NAME, or TYPE/NAME@NODE, and a pod of a
+// resource is picked with the picker of the targets.
+func resolveSourcePod(ctx context.Context, resolver *podResolver, source string, picker podPicker) (*corev1.Pod, error) {
+	sourceURL, err := url.Parse("http://" + source)
+	if err != nil {
+		return nil, fmt.Errorf("malformed --from source: %w", err)
+	}
+	target, err := curl.ParseResourceTarget(sourceURL, resolver.lookupResourceType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --from source: %w", err)
+	}
+	switch {
+	case target.Context != "":
+		return nil, fmt.Errorf("the --from source %s must be in the cluster of the target, it cannot select a context", source)
+	case target.PodPort != "" || strings.Trim(target.NewPath, "/") != "":
+		return nil, fmt.Errorf("the --from source %s must not have a port or a path", source)
+	}
+
+	r := *resolver
+	if target.Namespace != "" {
+		r.namespace = target.Namespace
+	}
+
+	var pod *corev1.Pod
+	switch {
+	case target.PodIP != "":
+		allNamespaces := target.Namespace == "" && (config.Namespace == nil || *config.Namespace == "")
+		if pod, err = r.resolvePodFromIP(ctx, target.PodIP, allNamespaces); err != nil {
+			return nil, err
+		}
+
+	case !target.IsResource:
+		if target.PodName == "" || target.PodName == "_" {
+			return nil, fmt.Errorf("missing pod name in the --from source %s", source)
+		}
+		if pod, err = r.client.CoreV1().Pods(r.namespace).Get(ctx, target.PodName, metav1.GetOptions{}); err != nil {
+			return nil, err
+		}
+
+	default:
+		name := target.ResourceType + "/" + target.ResourceName
+		pods, err := r.resolvePodsFromResource(ctx, target.ResourceType, target.ResourceName)
+		if err != nil {
+			return nil, err
+		}
+		if target.NodeName != "" {
+			pods = podsOnNode(pods, target.NodeName)
+			if len(pods) == 0 {
+				return nil, fmt.Errorf("no pods of %s are scheduled on node %s", name, target.NodeName)
+			}
+		}
+		eligible := eligiblePods(pods, includeUnready)
+		if len(eligible) == 0 {
+			return nil, fmt.Errorf("none of the %d pods of %s are ready, use --include-unready to send the request anyway", len(pods), name)
+		}
+		if pod, err = picker.pick(eligible); err != nil {
+			return nil, fmt.Errorf("unable to pick a pod of %s: %w", name, err)
+		}
+	}
+
+	if pod.Status.Phase != corev1.PodRunning {
+		return nil, fmt.Errorf("unable to send the request from pod/%s because it is not running. Current status=%v", pod.Name, pod.Status.Phase)
+	}
+	return pod, nil
+}
+
+// curlFrom sends the request of req from the source pod of req, with the curl
+// binary of its container or of an ephemeral container as selected by --via.
+// The URL of req must address the destination from inside the cluster.
+func curlFrom(ctx context.Context, req curlRequest) error {
+	if debug || isVerbose(req.args) {
+		_, _ = fmt.Fprintf(req.stderr, "Sending the request to %s from pod/%s\n", req.requestURL.Host, req.sourcePod.Name)
+	}
+	if via == "debug" {
+		return curlDebug(ctx, req, req.sourcePod, "", 0)
+	}
+	return curlExec(ctx, req, req.sourcePod, "", 0)
+}
+
+// curlServiceFrom sends the request of req from the source pod of req to the
+// service of req, addressed by its cluster DNS name and the number of the
+// selected service port.
+func curlServiceFrom(ctx context.Context, req curlRequest) error {
+	host, err := serviceHostFrom(ctx, req)
+	if err != nil {
+		return err
+	}
+	req.requestURL.Host = host
+	return curlFrom(ctx, req)
+}
+
+// serviceHostFrom returns the host of the URL that the source pod of req sends
+// the request to the service of req with: the host of the URL of req, and the
+// number of the service port selected by the port of req, or by the scheme.
+func serviceHostFrom(ctx context.Context, req curlRequest) (string, error) {
+	service, err := req.client.CoreV1().Services(req.namespace).Get(ctx, req.serviceName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get service %s: %w", req.serviceName, err)
+	}
+	port, err := selectServicePort(service, req.podPort, req.requestURL.Scheme)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(req.requestURL.Hostname(), strconv.Itoa(int(port.Port))), nil
+}
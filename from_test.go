@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func sourcePod(name string, phase corev1.PodPhase) *corev1.Pod {
+	pod := labeledPod(name, map[string]string{"app": "web"})
+	pod.Status = corev1.PodStatus{
+		Phase:      phase,
+		Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+	}
+	return pod
+}
+
+func TestResolveSourcePod(t *testing.T) {
+	r := newTestResolver(
+		[]runtime.Object{
+			sourcePod("web-1", corev1.PodRunning),
+			sourcePod("web-2", corev1.PodPending),
+		},
+		testWorkload(deploymentsResource, "Deployment", "web", map[string]interface{}{
+			"matchLabels": map[string]interface{}{"app": "web"},
+		}),
+	)
+	picker, err := parsePodPicker("ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		source string
+		pod    string
+		err    string
+	}{
+		{source: "web-1", pod: "web-1"},
+		{source: "web-1.ns", pod: "web-1"},
+		{source: "deployments/web", pod: "web-1"},
+		{source: "deployments/web@@prod", err: "cannot select a context"},
+		{source: "web-1:8080", err: "must not have a port or a path"},
+		{source: "web-1/healthz", err: "must not have a port or a path"},
+		{source: "deployments/web:8080", err: "must not have a port or a path"},
+		{source: "_", err: "missing pod name"},
+		{source: "", err: "missing pod name"},
+		{source: "Web-1", err: "invalid --from source"},
+		{source: "web-2", err: "not running"},
+		{source: "web-3", err: "not found"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			pod, err := resolveSourcePod(context.Background(), r, test.source, picker)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected an error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pod.Name != test.pod {
+				t.Errorf("got pod/%s, want pod/%s", pod.Name, test.pod)
+			}
+		})
+	}
+}
+
+func TestServiceHostFrom(t *testing.T) {
+	r := newTestResolver([]runtime.Object{
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns"},
+			Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080), Protocol: corev1.ProtocolTCP},
+				{Name: "metrics", Port: 9090, Protocol: corev1.ProtocolTCP},
+				{Name: "dns", Port: 53, Protocol: corev1.ProtocolUDP},
+			}},
+		},
+	})
+
+	tests := []struct {
+		scheme string
+		port   string
+		host   string
+		err    bool
+	}{
+		// The service port is used, not the target port, since the request
+		// goes through the service from inside the cluster.
+		{scheme: "http", host: "web.ns.svc:80"},
+		{scheme: "http", port: "metrics", host: "web.ns.svc:9090"},
+		{scheme: "http", port: "9090", host: "web.ns.svc:9090"},
+		{scheme: "https", err: true},
+		{scheme: "http", port: "dns", err: true},
+		{scheme: "http", port: "8080", err: true},
+	}
+
+	for _, test := range tests {
+		req := curlRequest{
+			client:      r.client,
+			namespace:   "ns",
+			serviceName: "web",
+			podPort:     test.port,
+			requestURL:  url.URL{Scheme: test.scheme, Host: "web.ns.svc", Path: "/"},
+		}
+		host, err := serviceHostFrom(context.Background(), req)
+		switch {
+		case test.err && err == nil:
+			t.Errorf("%s %q: expected an error, got %s", test.scheme, test.port, host)
+		case !test.err && err != nil:
+			t.Errorf("%s %q: %v", test.scheme, test.port, err)
+		case host != test.host:
+			t.Errorf("%s %q: got %q, want %q", test.scheme, test.port, host, test.host)
+		}
+	}
+
+	req := curlRequest{client: r.client, namespace: "ns", serviceName: "missing", requestURL: url.URL{Scheme: "http", Host: "missing.ns.svc"}}
+	if _, err := serviceHostFrom(context.Background(), req); err == nil {
+		t.Error("missing service: expected an error")
+	}
+}
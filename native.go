@@ -0,0 +1,1030 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// Exit codes of curl reproduced by the native executor.
+const (
+	curlFailInitExitCode         = 2
+	curlConnectExitCode          = 7
+	curlHTTPErrorExitCode        = 22
+	curlWriteErrorExitCode       = 23
+	curlTimeoutExitCode          = 28
+	curlTooManyRedirectsExitCode = 47
+)
+
+// curlError is returned by the native executor when a request fails, with the
+// exit code that curl would have returned.
+type curlError struct {
+	code int
+	err  error
+}
+
+func (e *curlError) Error() string { return fmt.Sprintf("curl: (%d) %s", e.code, e.err) }
+func (e *curlError) Unwrap() error { return e.err }
+
+// curlExitCode returns the curl exit code of an error returned by the curl
+// binary, by the native executor, or by curl ran in a container, or zero if err
+// is not such an error.
+func curlExitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	var curlErr *curlError
+	if errors.As(err, &curlErr) {
+		return curlErr.code
+	}
+	var remoteErr utilexec.ExitError
+	if errors.As(err, &remoteErr) {
+		return remoteErr.ExitStatus()
+	}
+	return 0
+}
+
+// unsupportedOptionError is returned when the native executor is unable to
+// interpret a curl option.
+type unsupportedOptionError struct {
+	option string
+}
+
+func (e *unsupportedOptionError) Error() string {
+	return fmt.Sprintf("option %s is not supported by the native executor", e.option)
+}
+
+// nativeCurlFor returns the request of the native executor for the curl
+// arguments, or nil if the request must be sent with the curl binary, as
+// selected by the --executor flag. In auto mode, the native executor is used
+// unless one of the arguments is not supported, then the request falls back
+// to the curl binary.
+func nativeCurlFor(req curlRequest, args []string) (*nativeCurl, error) {
+	if executor == "curl" {
+		return nil, nil
+	}
+
+	n, err := parseNativeArgs(args, req.stdin)
+	if err != nil {
+		var unsupported *unsupportedOptionError
+		if executor == "native" || !errors.As(err, &unsupported) {
+			return nil, err
+		}
+		if _, lookErr := exec.LookPath("curl"); lookErr != nil {
+			return nil, fmt.Errorf("curl was not found in PATH, and %w", err)
+		}
+		if hasConfigSecrets() && isVerbose(args) {
+			return nil, fmt.Errorf("%w, and %s", err, verboseSecretsError)
+		}
+		log.Printf("%s, falling back to the curl binary", err)
+		return nil, nil
+	}
+	return n, nil
+}
+
+func execCurl(ctx context.Context, req curlRequest, args []string) error {
+	cmd := exec.CommandContext(ctx, "curl", args...)
+	cmd.Stdin = req.stdin
+	cmd.Stdout = req.stdout
+	cmd.Stderr = req.stderr
+	log.Printf("curl %s", prettyArgs(cmd.Args[1:]))
+	return cmd.Run()
+}
+
+// runCurl sends the request of args with the executor selected by the
+// --executor flag, connecting to the hosts that the arguments route to.
+func runCurl(ctx context.Context, req curlRequest, args []string) error {
+	n, err := nativeCurlFor(req, args)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		return execCurl(ctx, req, args)
+	}
+	log.Printf("native curl %s", prettyArgs(args))
+	return n.run(ctx, req.stdout, req.stderr)
+}
+
+// nativeCurl is a request sent by the native executor, built from the subset
+// of curl options that it supports. Requests are sent with transport when it
+// is set, instead of connecting to the hosts of their URLs.
+type nativeCurl struct {
+	stdin          io.Reader
+	podHost        string
+	dialPod        func(context.Context) (net.Conn, error)
+	transport      http.RoundTripper
+	url            string
+	method         string
+	header         http.Header
+	data           []curlData
+	hasData        bool
+	get            bool
+	head           bool
+	user           string
+	bearer         string
+	output         string
+	include        bool
+	fail           bool
+	verbose        bool
+	maxTime        time.Duration
+	connectTimeout time.Duration
+	compressed     bool
+	location       bool
+	maxRedirs      int
+	cookies        []string
+	cookieFiles    []string
+	cookieJar      string
+	insecure       bool
+	caCert         string
+	cert           string
+	key            string
+	connectTo      []connectToRule
+	writeOut       string
+}
+
+// curlData is the value of a --data option, read when the request is sent
+// since it may come from the standard input.
+type curlData struct {
+	option string
+	value  string
+}
+
+// connectToRule is a --connect-to or --resolve rule, empty fields match any
+// host or port, or keep the host or port of the request.
+type connectToRule struct {
+	host1, port1 string
+	host2, port2 string
+}
+
+// parseNativeArgs parses the curl arguments given to runCurl. Options which
+// are not supported return an *unsupportedOptionError naming the option. Data
+// given with @- is read from stdin, which may be nil.
+func parseNativeArgs(args []string, stdin io.Reader) (*nativeCurl, error) {
+	n := &nativeCurl{stdin: stdin, header: make(http.Header), maxRedirs: 50}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			if n.url != "" {
+				return nil, &unsupportedOptionError{option: "multiple URLs"}
+			}
+			n.url = arg
+			continue
+		}
+
+		name := arg
+		f := lookupCurlFlag(name)
+		if f == nil {
+			return nil, &unsupportedOptionError{option: name}
+		}
+		value := ""
+		if f.NoOptDefVal == "" {
+			if i++; i == len(args) {
+				return nil, fmt.Errorf("option %s requires a value", name)
+			}
+			value = args[i]
+		}
+		if err := n.setOption(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if n.url == "" {
+		return nil, fmt.Errorf("no URL specified")
+	}
+	return n, nil
+}
+
+// lookupCurlFlag returns the flag of a curl option, undoing the renames of
+// options which conflict with the kubectl options.
+func lookupCurlFlag(name string) *pflag.Flag {
+	name = strings.TrimPrefix(name, "--")
+	if renamed, ok := renamedOptions[name]; ok {
+		name = renamed
+	}
+	return cflags.Lookup(name)
+}
+
+func (n *nativeCurl) setOption(name, value string) (err error) {
+	switch name {
+	case "--request":
+		n.method = value
+	case "--header":
+		err = n.addHeader(value)
+	case "--data", "--data-ascii", "--data-binary", "--data-raw", "--data-urlencode":
+		n.data, n.hasData = append(n.data, curlData{option: name, value: value}), true
+	case "--get":
+		n.get = true
+	case "--head":
+		n.head = true
+	case "--user":
+		n.user = value
+	case "--basic":
+	case "--oauth2-bearer":
+		n.bearer = value
+	case "--user-agent":
+		n.header.Set("User-Agent", value)
+	case "--referer":
+		n.header.Set("Referer", value)
+	case "--compressed":
+		// The transport requests compressed responses and decompresses
+		// them when no Accept-Encoding header was set.
+		n.compressed = true
+	case "--output":
+		n.output = value
+	case "--include":
+		n.include = true
+	case "--fail":
+		n.fail = true
+	case "--silent", "--show-error":
+		// Errors are always reported by kubectl curl.
+	case "--verbose":
+		n.verbose = true
+	case "--max-time":
+		n.maxTime, err = parseCurlSeconds(name, value)
+	case "--connect-timeout":
+		n.connectTimeout, err = parseCurlSeconds(name, value)
+	case "--location":
+		n.location = true
+	case "--max-redirs":
+		n.maxRedirs, err = strconv.Atoi(value)
+	case "--cookie":
+		if strings.Contains(value, "=") {
+			n.cookies = append(n.cookies, value)
+		} else {
+			n.cookieFiles = append(n.cookieFiles, value)
+		}
+	case "--cookie-jar":
+		n.cookieJar = value
+	case "--insecure":
+		n.insecure = true
+	case "--cacert":
+		n.caCert = value
+	case "--cert":
+		n.cert = value
+	case "--key":
+		n.key = value
+	case "--connect-to":
+		var rule connectToRule
+		rule, err = parseConnectTo(value)
+		n.connectTo = append(n.connectTo, rule)
+	case "--resolve":
+		var rule connectToRule
+		rule, err = parseResolve(value)
+		n.connectTo = append(n.connectTo, rule)
+	case "--write-out":
+		if strings.HasPrefix(value, "@") {
+			return &unsupportedOptionError{option: name + " @file"}
+		}
+		n.writeOut = value
+	case "--config":
+		err = n.readConfig(value)
+	default:
+		return &unsupportedOptionError{option: name}
+	}
+	if err != nil {
+		var unsupported *unsupportedOptionError
+		if errors.As(err, &unsupported) {
+			return err
+		}
+		return fmt.Errorf("invalid value of %s: %w", name, err)
+	}
+	return nil
+}
+
+func (n *nativeCurl) addHeader(value string) error {
+	if strings.HasPrefix(value, "@") {
+		b, err := os.ReadFile(value[1:])
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				if err := n.addHeader(line); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	name, val, ok := strings.Cut(value, ":")
+	if !ok {
+		if name, ok = strings.CutSuffix(value, ";"); ok {
+			// "Name;" sends the header with an empty value.
+			n.header.Add(name, "")
+			return nil
+		}
+		return fmt.Errorf("malformed header %q", value)
+	}
+	if val = strings.TrimSpace(val); val == "" {
+		// "Name:" removes a header that would be sent by default.
+		n.header.Del(name)
+		n.header[http.CanonicalHeaderKey(name)] = nil
+		return nil
+	}
+	n.header.Add(name, val)
+	return nil
+}
+
+// readConfig reads the options of a curl config file.
+func (n *nativeCurl) readConfig(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	options, err := parseCurlConfig(b)
+	if err != nil {
+		return err
+	}
+	for _, opt := range options {
+		if err := n.setOption(opt.name, opt.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// curlConfigOption is an option of a curl config file, the value of boolean
+// options is empty.
+type curlConfigOption struct {
+	name  string
+	value string
+}
+
+// parseCurlConfig parses the options of a curl config file, in the form
+// "name = value" with optionally quoted values.
+func parseCurlConfig(b []byte) ([]curlConfigOption, error) {
+	var options []curlConfigOption
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		name, value := line, ""
+		if i := strings.IndexAny(line, " \t=:"); i >= 0 {
+			name, value = line[:i], strings.TrimLeft(line[i:], " \t=:")
+		}
+		if strings.HasPrefix(value, `"`) {
+			var err error
+			if value, err = unquoteCurlConfig(value); err != nil {
+				return nil, err
+			}
+		}
+		name = "--" + strings.TrimPrefix(name, "--")
+		f := lookupCurlFlag(name)
+		if f == nil || (f.NoOptDefVal != "" && value != "") {
+			return nil, &unsupportedOptionError{option: name + " in config file"}
+		}
+		options = append(options, curlConfigOption{name: name, value: value})
+	}
+	return options, nil
+}
+
+// unquoteCurlConfig is the reverse of curlConfigQuote.
+func unquoteCurlConfig(s string) (string, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if i++; i == len(s) {
+				break
+			}
+			switch c = s[i]; c {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'v':
+				b.WriteByte('\v')
+			default:
+				b.WriteByte(c)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated quoted value: %s", s)
+}
+
+// readCurlData returns the data of a --data option, reading it from a file or
+// the standard input for @file and @- values the same way curl does.
+func readCurlData(name, value string, stdin io.Reader) ([]byte, error) {
+	switch name {
+	case "--data-raw":
+		return []byte(value), nil
+
+	case "--data-urlencode":
+		key, content, hasKey := strings.Cut(value, "=")
+		if !hasKey {
+			if key, content, hasKey = strings.Cut(value, "@"); hasKey {
+				b, err := readFileOrStdin(content, stdin)
+				if err != nil {
+					return nil, err
+				}
+				content = string(b)
+			} else {
+				key, content = "", value
+			}
+		}
+		encoded := url.QueryEscape(content)
+		if key != "" {
+			encoded = key + "=" + encoded
+		}
+		return []byte(encoded), nil
+	}
+
+	if !strings.HasPrefix(value, "@") {
+		return []byte(value), nil
+	}
+	b, err := readFileOrStdin(value[1:], stdin)
+	if err != nil {
+		return nil, err
+	}
+	if name != "--data-binary" {
+		// curl strips carriage returns and newlines from files given to
+		// --data and --data-ascii.
+		b = bytes.ReplaceAll(bytes.ReplaceAll(b, []byte("\r"), nil), []byte("\n"), nil)
+	}
+	return b, nil
+}
+
+func readFileOrStdin(path string, stdin io.Reader) ([]byte, error) {
+	if path == "-" {
+		if stdin == nil {
+			return nil, nil
+		}
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(path)
+}
+
+func parseCurlSeconds(name, value string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("expected a number of seconds, got %q", value)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// parseConnectTo parses a --connect-to value: HOST1:PORT1:HOST2:PORT2, where
+// IPv6 addresses are enclosed in brackets.
+func parseConnectTo(s string) (connectToRule, error) {
+	fields, err := splitHostFields(s, 4)
+	if err != nil {
+		return connectToRule{}, err
+	}
+	return connectToRule{host1: fields[0], port1: fields[1], host2: fields[2], port2: fields[3]}, nil
+}
+
+// parseResolve parses a --resolve value: HOST:PORT:ADDRESS.
+func parseResolve(s string) (connectToRule, error) {
+	fields, err := splitHostFields(s, 3)
+	if err != nil {
+		return connectToRule{}, err
+	}
+	if strings.Contains(fields[2], ",") {
+		return connectToRule{}, &unsupportedOptionError{option: "--resolve with multiple addresses"}
+	}
+	return connectToRule{host1: fields[0], port1: fields[1], host2: fields[2], port2: fields[1]}, nil
+}
+
+func splitHostFields(s string, count int) ([]string, error) {
+	fields := make([]string, 0, count)
+	for len(fields) < count-1 {
+		var field string
+		if strings.HasPrefix(s, "[") {
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("missing ] in %q", s)
+			}
+			field, s = s[1:end], s[end+1:]
+			if !strings.HasPrefix(s, ":") {
+				return nil, fmt.Errorf("malformed value %q", s)
+			}
+			s = s[1:]
+		} else {
+			var ok bool
+			if field, s, ok = strings.Cut(s, ":"); !ok {
+				return nil, fmt.Errorf("expected %d fields separated by colons", count)
+			}
+		}
+		fields = append(fields, field)
+	}
+	return append(fields, strings.Trim(s, "[]")), nil
+}
+
+// dialAddress returns the address that connections to addr are sent to after
+// applying the --connect-to and --resolve rules.
+func (n *nativeCurl) dialAddress(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	for _, rule := range n.connectTo {
+		if (rule.host1 == "" || rule.host1 == host) && (rule.port1 == "" || rule.port1 == port) {
+			if rule.host2 != "" {
+				host = rule.host2
+			}
+			if rule.port2 != "" {
+				port = rule.port2
+			}
+			break
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func (n *nativeCurl) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: n.insecure}
+
+	if n.caCert != "" {
+		pem, err := os.ReadFile(n.caCert)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", n.caCert)
+		}
+	}
+
+	if n.cert != "" {
+		key := n.key
+		if key == "" {
+			key = n.cert
+		}
+		cert, err := tls.LoadX509KeyPair(n.cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// newRequest builds the HTTP request described by the options.
+func (n *nativeCurl) newRequest(ctx context.Context) (*http.Request, error) {
+	requestURL, err := url.Parse(n.url)
+	if err != nil {
+		return nil, err
+	}
+	if requestURL.Scheme != "http" && requestURL.Scheme != "https" {
+		return nil, &unsupportedOptionError{option: requestURL.Scheme + " URLs"}
+	}
+
+	data := make([][]byte, len(n.data))
+	for i, d := range n.data {
+		if data[i], err = readCurlData(d.option, d.value, n.stdin); err != nil {
+			return nil, err
+		}
+	}
+
+	method := http.MethodGet
+	var body []byte
+	switch {
+	case n.head:
+		method = http.MethodHead
+	case n.hasData && n.get:
+		query := string(bytes.Join(data, []byte("&")))
+		if requestURL.RawQuery != "" {
+			query = requestURL.RawQuery + "&" + query
+		}
+		requestURL.RawQuery = query
+	case n.hasData:
+		method = http.MethodPost
+		body = bytes.Join(data, []byte("&"))
+	}
+	if n.method != "" {
+		method = n.method
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body, req.ContentLength = http.NoBody, 0
+	}
+
+	req.Header.Set("User-Agent", "curl")
+	req.Header.Set("Accept", "*/*")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	switch {
+	case n.bearer != "":
+		req.Header.Set("Authorization", "Bearer "+n.bearer)
+	case n.user != "":
+		user, password, _ := strings.Cut(n.user, ":")
+		req.SetBasicAuth(user, password)
+	case requestURL.User != nil:
+		password, _ := requestURL.User.Password()
+		req.SetBasicAuth(requestURL.User.Username(), password)
+	}
+	if len(n.cookies) != 0 {
+		req.Header.Set("Cookie", strings.Join(n.cookies, "; "))
+	}
+	for name, values := range n.header {
+		if values == nil {
+			req.Header.Del(name)
+		} else {
+			req.Header[name] = values
+		}
+	}
+	if host := n.header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	return req, nil
+}
+
+// run sends the request and writes the response the way curl would.
+func (n *nativeCurl) run(ctx context.Context, stdout, stderr io.Writer) error {
+	if n.maxTime != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.maxTime)
+		defer cancel()
+	}
+
+	req, err := n.newRequest(ctx)
+	if err != nil {
+		return &curlError{code: curlFailInitExitCode, err: err}
+	}
+	tlsConfig, err := n.tlsConfig()
+	if err != nil {
+		return &curlError{code: curlFailInitExitCode, err: err}
+	}
+
+	jar, err := newCookieRecorder(n.cookieFiles)
+	if err != nil {
+		return &curlError{code: curlFailInitExitCode, err: err}
+	}
+
+	dialer := &net.Dialer{Timeout: n.connectTimeout}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if host, _, _ := net.SplitHostPort(addr); n.dialPod != nil && host == n.podHost {
+				return n.dialPodWithTimeout(ctx)
+			}
+			return dialer.DialContext(ctx, network, n.dialAddress(addr))
+		},
+		TLSClientConfig: tlsConfig,
+		// Like curl, responses are only requested compressed with
+		// --compressed, and written as received otherwise.
+		DisableCompression: !n.compressed,
+		ForceAttemptHTTP2:  true,
+	}
+	defer transport.CloseIdleConnections()
+
+	var roundTripper http.RoundTripper = transport
+	if n.transport != nil {
+		roundTripper = n.transport
+	}
+
+	redirects := 0
+	client := &http.Client{
+		Transport: roundTripper,
+		Jar:       jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !n.location {
+				return http.ErrUseLastResponse
+			}
+			if redirects++; n.maxRedirs >= 0 && redirects > n.maxRedirs {
+				return &curlError{code: curlTooManyRedirectsExitCode, err: fmt.Errorf("maximum (%d) redirects followed", n.maxRedirs)}
+			}
+			if n.verbose {
+				_, _ = fmt.Fprintf(stderr, "* Issue another request to this URL: '%s'\n", req.URL)
+			}
+			return nil
+		},
+	}
+
+	if n.verbose {
+		writeVerboseRequest(stderr, req)
+	}
+
+	start := time.Now()
+	res, err := client.Do(req)
+	if err != nil {
+		return n.requestError(ctx, err)
+	}
+	defer res.Body.Close()
+
+	if n.verbose {
+		writeVerboseResponse(stderr, res)
+	}
+
+	if n.cookieJar != "" {
+		defer func() {
+			if err := jar.save(n.cookieJar); err != nil {
+				_, _ = fmt.Fprintf(stderr, "* WARNING: failed to save cookies in %s: %s\n", n.cookieJar, err)
+			}
+		}()
+	}
+
+	if n.fail && res.StatusCode >= 400 {
+		return &curlError{code: curlHTTPErrorExitCode, err: fmt.Errorf("The requested URL returned error: %d", res.StatusCode)}
+	}
+
+	out, closeOutput, err := n.openOutput(stdout)
+	if err != nil {
+		return &curlError{code: curlWriteErrorExitCode, err: err}
+	}
+	defer closeOutput()
+
+	if n.include || n.head {
+		if err := writeResponseHeader(out, res); err != nil {
+			return &curlError{code: curlWriteErrorExitCode, err: err}
+		}
+	}
+	size, err := io.Copy(out, res.Body)
+	if err != nil {
+		return n.requestError(ctx, err)
+	}
+	if err := closeOutput(); err != nil {
+		return &curlError{code: curlWriteErrorExitCode, err: err}
+	}
+
+	if n.writeOut != "" {
+		_, _ = io.WriteString(stdout, expandWriteOut(n.writeOut, res, size, redirects, time.Since(start)))
+	}
+	return nil
+}
+
+// dialPodWithTimeout opens a connection to the pod, within the time of
+// --connect-timeout if it was given.
+func (n *nativeCurl) dialPodWithTimeout(ctx context.Context) (net.Conn, error) {
+	if n.connectTimeout == 0 {
+		return n.dialPod(ctx)
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, n.connectTimeout)
+	defer cancel()
+	conn, err := n.dialPod(dialCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(dialCtx.Err(), context.DeadlineExceeded) {
+		return nil, &curlError{code: curlTimeoutExitCode, err: fmt.Errorf("Connection timed out after %s", n.connectTimeout)}
+	}
+	return conn, err
+}
+
+func (n *nativeCurl) requestError(ctx context.Context, err error) error {
+	var curlErr *curlError
+	if errors.As(err, &curlErr) {
+		return curlErr
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &curlError{code: curlTimeoutExitCode, err: fmt.Errorf("Operation timed out after %s", n.maxTime)}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &curlError{code: curlTimeoutExitCode, err: err}
+	}
+	return &curlError{code: curlConnectExitCode, err: err}
+}
+
+// openOutput returns the writer of the response body, and a function closing
+// it which may be called multiple times.
+func (n *nativeCurl) openOutput(stdout io.Writer) (io.Writer, func() error, error) {
+	if n.output == "" || n.output == "-" {
+		return stdout, func() error { return nil }, nil
+	}
+	f, err := os.Create(n.output)
+	if err != nil {
+		return nil, nil, err
+	}
+	var once sync.Once
+	var closeErr error
+	return f, func() error {
+		once.Do(func() { closeErr = f.Close() })
+		return closeErr
+	}, nil
+}
+
+func writeVerboseRequest(w io.Writer, req *http.Request) {
+	_, _ = fmt.Fprintf(w, "> %s %s HTTP/1.1\n", req.Method, req.URL.RequestURI())
+	_, _ = fmt.Fprintf(w, "> Host: %s\n", req.URL.Host)
+	writeVerboseHeader(w, "> ", req.Header)
+	_, _ = fmt.Fprintln(w, ">")
+}
+
+func writeVerboseResponse(w io.Writer, res *http.Response) {
+	_, _ = fmt.Fprintf(w, "< %s %s\n", res.Proto, res.Status)
+	writeVerboseHeader(w, "< ", res.Header)
+	_, _ = fmt.Fprintln(w, "<")
+}
+
+func writeVerboseHeader(w io.Writer, prefix string, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			if sensitiveHeaders[strings.ToLower(name)] {
+				value = "<redacted>"
+			}
+			_, _ = fmt.Fprintf(w, "%s%s: %s\n", prefix, name, value)
+		}
+	}
+}
+
+func writeResponseHeader(w io.Writer, res *http.Response) error {
+	if _, err := fmt.Fprintf(w, "%s %s\r\n", res.Proto, res.Status); err != nil {
+		return err
+	}
+	if err := res.Header.Write(w); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\r\n")
+	return err
+}
+
+// expandWriteOut expands the --write-out variables supported by the native
+// executor. Unknown variables expand to an empty string.
+func expandWriteOut(format string, res *http.Response, size int64, redirects int, elapsed time.Duration) string {
+	format = strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\t`, "\t").Replace(format)
+
+	var b strings.Builder
+	for {
+		i := strings.Index(format, "%{")
+		if i < 0 {
+			b.WriteString(format)
+			return b.String()
+		}
+		j := strings.IndexByte(format[i:], '}')
+		if j < 0 {
+			b.WriteString(format)
+			return b.String()
+		}
+		b.WriteString(format[:i])
+		switch variable := format[i+2 : i+j]; variable {
+		case "http_code", "response_code":
+			fmt.Fprintf(&b, "%03d", res.StatusCode)
+		case "time_total":
+			fmt.Fprintf(&b, "%.6f", elapsed.Seconds())
+		case "size_download":
+			fmt.Fprintf(&b, "%d", size)
+		case "url_effective":
+			b.WriteString(res.Request.URL.String())
+		case "content_type":
+			b.WriteString(res.Header.Get("Content-Type"))
+		case "num_redirects":
+			fmt.Fprintf(&b, "%d", redirects)
+		case "redirect_url":
+			if location, err := res.Location(); err == nil {
+				b.WriteString(location.String())
+			}
+		case "method":
+			b.WriteString(res.Request.Method)
+		}
+		format = format[i+j+1:]
+	}
+}
+
+// cookieRecorder is the cookie jar of the native executor. It sends the
+// cookies loaded from Netscape cookie files given with --cookie, and records
+// the cookies set by responses so they can be saved with --cookie-jar.
+type cookieRecorder struct {
+	mutex   sync.Mutex
+	cookies []*http.Cookie
+}
+
+func newCookieRecorder(files []string) (*cookieRecorder, error) {
+	jar := new(cookieRecorder)
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		err = jar.load(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cookies from %s: %w", path, err)
+		}
+	}
+	return jar, nil
+}
+
+func (jar *cookieRecorder) load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("malformed cookie line: %q", line)
+		}
+		expires, _ := strconv.ParseInt(fields[4], 10, 64)
+		cookie := &http.Cookie{
+			Domain:   fields[0],
+			Path:     fields[2],
+			Secure:   fields[3] == "TRUE",
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+		if expires != 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+		jar.cookies = append(jar.cookies, cookie)
+	}
+	return scanner.Err()
+}
+
+func (jar *cookieRecorder) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	jar.mutex.Lock()
+	defer jar.mutex.Unlock()
+
+	for _, cookie := range cookies {
+		if cookie.Domain == "" {
+			cookie.Domain = u.Hostname()
+		}
+		if cookie.Path == "" {
+			cookie.Path = "/"
+		}
+		replaced := false
+		for i, c := range jar.cookies {
+			if c.Name == cookie.Name && c.Domain == cookie.Domain && c.Path == cookie.Path {
+				jar.cookies[i], replaced = cookie, true
+			}
+		}
+		if !replaced {
+			jar.cookies = append(jar.cookies, cookie)
+		}
+	}
+}
+
+func (jar *cookieRecorder) Cookies(u *url.URL) []*http.Cookie {
+	jar.mutex.Lock()
+	defer jar.mutex.Unlock()
+
+	var cookies []*http.Cookie
+	now := time.Now()
+	for _, c := range jar.cookies {
+		domain := strings.TrimPrefix(c.Domain, ".")
+		if (c.Expires.IsZero() || c.Expires.After(now)) &&
+			(u.Hostname() == domain || strings.HasSuffix(u.Hostname(), "."+domain)) &&
+			strings.HasPrefix(u.Path+"/", strings.TrimSuffix(c.Path, "/")+"/") &&
+			(!c.Secure || u.Scheme == "https") {
+			cookies = append(cookies, &http.Cookie{Name: c.Name, Value: c.Value})
+		}
+	}
+	return cookies
+}
+
+// save writes the cookies in the Netscape format used by curl.
+func (jar *cookieRecorder) save(path string) error {
+	jar.mutex.Lock()
+	defer jar.mutex.Unlock()
+
+	var b strings.Builder
+	b.WriteString("# Netscape HTTP Cookie File\n")
+	for _, c := range jar.cookies {
+		domain := c.Domain
+		if c.HttpOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, curlBool(strings.HasPrefix(c.Domain, ".")), c.Path, curlBool(c.Secure), expires, c.Name, c.Value)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0600)
+}
+
+func curlBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
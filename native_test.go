@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNativeCurl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		user, password, _ := r.BasicAuth()
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/echo", http.StatusFound)
+		case "/missing":
+			http.NotFound(w, r)
+		case "/encoding":
+			fmt.Fprintf(w, "accept-encoding=%s", r.Header.Get("Accept-Encoding"))
+		default:
+			w.Header().Set("X-Test", "yes")
+			fmt.Fprintf(w, "%s %s host=%s api-key=%s auth=%s:%s body=%s", r.Method, r.URL.RequestURI(), r.Host, r.Header.Get("X-Api-Key"), user, password, body)
+		}
+	}))
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	connectTo := []string{"--connect-to", "myservice.default.svc::127.0.0.1:" + port}
+
+	config := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(config, []byte("header = "+curlConfigQuote("X-Api-Key: secret")+"\nuser = \"alice:pa:ss\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		scenario string
+		args     []string
+		output   string
+		exitCode int
+	}{
+		{
+			scenario: "get",
+			args:     []string{"http://myservice.default.svc/echo?a=1"},
+			output:   "GET /echo?a=1 host=myservice.default.svc api-key= auth=: body=",
+		},
+		{
+			scenario: "post data with headers and credentials from a config file",
+			args:     []string{"--data", "a=1", "--data", "b=2", "--config", config, "http://myservice.default.svc/echo"},
+			output:   "POST /echo host=myservice.default.svc api-key=secret auth=alice:pa:ss body=a=1&b=2",
+		},
+		{
+			scenario: "get data",
+			args:     []string{"--get", "--data-urlencode", "q=a b", "http://myservice.default.svc/echo"},
+			output:   "GET /echo?q=a+b host=myservice.default.svc api-key= auth=: body=",
+		},
+		{
+			scenario: "custom method",
+			args:     []string{"--request", "PUT", "--data-binary", "{}", "http://myservice.default.svc/echo"},
+			output:   "PUT /echo host=myservice.default.svc api-key= auth=: body={}",
+		},
+		{
+			scenario: "redirects are not followed by default",
+			args:     []string{"--write-out", "%{http_code} %{redirect_url}", "http://myservice.default.svc/redirect"},
+			output:   "<a href=\"/echo\">Found</a>.\n\n302 http://myservice.default.svc/echo",
+		},
+		{
+			scenario: "redirects are followed with --location",
+			args:     []string{"--location", "http://myservice.default.svc/redirect"},
+			output:   "GET /echo host=myservice.default.svc api-key= auth=: body=",
+		},
+		{
+			scenario: "responses are not requested compressed by default",
+			args:     []string{"http://myservice.default.svc/encoding"},
+			output:   "accept-encoding=",
+		},
+		{
+			scenario: "responses are requested compressed with --compressed",
+			args:     []string{"--compressed", "http://myservice.default.svc/encoding"},
+			output:   "accept-encoding=gzip",
+		},
+		{
+			scenario: "include headers",
+			args:     []string{"--include", "--head", "http://myservice.default.svc/echo"},
+			output:   "HTTP/1.1 200 OK\r\n",
+		},
+		{
+			scenario: "fail on HTTP errors",
+			args:     []string{"--fail", "http://myservice.default.svc/missing"},
+			exitCode: curlHTTPErrorExitCode,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			n, err := parseNativeArgs(append(test.args, connectTo...), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+			err = n.run(context.Background(), stdout, stderr)
+			if exitCode := curlExitCode(err); exitCode != test.exitCode {
+				t.Fatalf("exit code mismatch: want=%d got=%d (%v)", test.exitCode, exitCode, err)
+			}
+			if !strings.HasPrefix(stdout.String(), test.output) {
+				t.Errorf("output mismatch:\nwant: %q\ngot:  %q", test.output, stdout.String())
+			}
+		})
+	}
+}
+
+func TestNativeCurlPodConnectTimeout(t *testing.T) {
+	n, err := parseNativeArgs([]string{"--connect-timeout", "0.05", "http://mypod:8080/"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.podHost = "mypod"
+	n.dialPod = func(ctx context.Context) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	err = n.run(context.Background(), stdout, stderr)
+	if exitCode := curlExitCode(err); exitCode != curlTimeoutExitCode {
+		t.Errorf("exit code mismatch: want=%d got=%d (%v)", curlTimeoutExitCode, exitCode, err)
+	}
+}
+
+func TestNativeCurlUnsupportedOption(t *testing.T) {
+	_, err := parseNativeArgs([]string{"--http2", "http://localhost/"}, nil)
+	var unsupported *unsupportedOptionError
+	if !errors.As(err, &unsupported) || unsupported.option != "--http2" {
+		t.Errorf("expected an error naming the unsupported option, got %v", err)
+	}
+}
+
+func TestParseConnectTo(t *testing.T) {
+	tests := []struct {
+		in   string
+		rule connectToRule
+	}{
+		{in: "example.com::localhost:8080", rule: connectToRule{host1: "example.com", host2: "localhost", port2: "8080"}},
+		{in: "[fd00::1]:443:[::1]:8443", rule: connectToRule{host1: "fd00::1", port1: "443", host2: "::1", port2: "8443"}},
+		{in: ":::", rule: connectToRule{}},
+	}
+
+	for _, test := range tests {
+		rule, err := parseConnectTo(test.in)
+		if err != nil {
+			t.Errorf("%s: %v", test.in, err)
+		} else if rule != test.rule {
+			t.Errorf("%s: rule mismatch: want=%+v got=%+v", test.in, test.rule, rule)
+		}
+	}
+}
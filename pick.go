@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// podPicker selects which pod of a resource receives the request.
+//
+// The strategy is one of:
+//   - ready: the first ready pod, ordered by name (the default)
+//   - random: a random pod
+//   - newest: the most recently created pod
+//   - oldest: the least recently created pod
+//   - index=N: the pod at index N, ordered by name
+//   - node=NAME: the pod scheduled on the node NAME
+type podPicker struct {
+	strategy string
+	index    int
+	node     string
+}
+
+func parsePodPicker(s string) (podPicker, error) {
+	strategy, arg, hasArg := strings.Cut(s, "=")
+
+	switch strategy {
+	case "ready", "random", "newest", "oldest":
+		if hasArg {
+			return podPicker{}, fmt.Errorf("pick strategy %s does not take a value", strategy)
+		}
+		return podPicker{strategy: strategy}, nil
+
+	case "index":
+		index, err := strconv.Atoi(arg)
+		if err != nil || index < 0 {
+			return podPicker{}, fmt.Errorf("invalid pod index: %q", arg)
+		}
+		return podPicker{strategy: strategy, index: index}, nil
+
+	case "node":
+		if arg == "" {
+			return podPicker{}, fmt.Errorf("missing node name in pick strategy %q", s)
+		}
+		return podPicker{strategy: strategy, node: arg}, nil
+	}
+
+	return podPicker{}, fmt.Errorf("unsupported pick strategy: %q (expected ready, random, newest, oldest, index=N, or node=NAME)", s)
+}
+
+func (p podPicker) String() string {
+	switch p.strategy {
+	case "index":
+		return "index=" + strconv.Itoa(p.index)
+	case "node":
+		return "node=" + p.node
+	}
+	return p.strategy
+}
+
+// pick returns the pod selected by the strategy. The pods are expected to have
+// been filtered with eligiblePods already.
+func (p podPicker) pick(pods []corev1.Pod) (*corev1.Pod, error) {
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("no eligible pods")
+	}
+
+	pods = sortedPods(pods)
+
+	switch p.strategy {
+	case "random":
+		return &pods[rand.Intn(len(pods))], nil
+
+	case "newest", "oldest":
+		selected := &pods[0]
+		for i := range pods[1:] {
+			pod := &pods[i+1]
+			created, selectedCreated := pod.CreationTimestamp, selected.CreationTimestamp
+			if (p.strategy == "newest" && selectedCreated.Before(&created)) ||
+				(p.strategy == "oldest" && created.Before(&selectedCreated)) {
+				selected = pod
+			}
+		}
+		return selected, nil
+
+	case "index":
+		if p.index >= len(pods) {
+			return nil, fmt.Errorf("pod index %d out of range, only %d eligible pods", p.index, len(pods))
+		}
+		return &pods[p.index], nil
+
+	case "node":
+		for i := range pods {
+			if pods[i].Spec.NodeName == p.node {
+				return &pods[i], nil
+			}
+		}
+		return nil, fmt.Errorf("no eligible pods on node %s", p.node)
+	}
+
+	for i := range pods {
+		if isPodReady(&pods[i]) {
+			return &pods[i], nil
+		}
+	}
+	return &pods[0], nil
+}
+
+// eligiblePods returns the pods that may receive requests: pods which are ready
+// and not being deleted, or every pod when includeUnready is true.
+func eligiblePods(pods []corev1.Pod, includeUnready bool) []corev1.Pod {
+	if includeUnready {
+		return pods
+	}
+	eligible := make([]corev1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if pod.DeletionTimestamp == nil && isPodReady(&pod) {
+			eligible = append(eligible, pod)
+		}
+	}
+	return eligible
+}
+
+// podsOnNode returns the pods scheduled on the node.
+func podsOnNode(pods []corev1.Pod, nodeName string) []corev1.Pod {
+	selected := make([]corev1.Pod, 0, 1)
+	for _, pod := range pods {
+		if pod.Spec.NodeName == nodeName {
+			selected = append(selected, pod)
+		}
+	}
+	return selected
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func sortedPods(pods []corev1.Pod) []corev1.Pod {
+	sorted := make([]corev1.Pod, len(pods))
+	copy(sorted, pods)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testPod(name, node string, ready bool, created time.Time) corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec: corev1.PodSpec{
+			NodeName: node,
+		},
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+func TestPodPicker(t *testing.T) {
+	now := time.Now()
+	terminating := testPod("web-d", "node-1", true, now)
+	terminating.DeletionTimestamp = &metav1.Time{Time: now}
+
+	pods := []corev1.Pod{
+		testPod("web-c", "node-3", true, now.Add(-1*time.Hour)),
+		testPod("web-a", "node-1", false, now.Add(-3*time.Hour)),
+		testPod("web-b", "node-2", true, now.Add(-2*time.Hour)),
+		terminating,
+	}
+
+	tests := []struct {
+		pick           string
+		includeUnready bool
+		want           string
+	}{
+		{pick: "ready", want: "web-b"},
+		{pick: "ready", includeUnready: true, want: "web-b"},
+		{pick: "newest", want: "web-c"},
+		{pick: "oldest", want: "web-b"},
+		{pick: "oldest", includeUnready: true, want: "web-a"},
+		{pick: "index=0", want: "web-b"},
+		{pick: "index=1", want: "web-c"},
+		{pick: "index=0", includeUnready: true, want: "web-a"},
+		{pick: "node=node-3", want: "web-c"},
+		{pick: "node=node-1", includeUnready: true, want: "web-a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pick, func(t *testing.T) {
+			picker, err := parsePodPicker(tt.pick)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pod, err := picker.pick(eligiblePods(pods, tt.includeUnready))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pod.Name != tt.want {
+				t.Errorf("pick %s = %s, want %s", tt.pick, pod.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodPickerErrors(t *testing.T) {
+	for _, pick := range []string{"", "first", "index=", "index=-1", "node=", "random=1"} {
+		if _, err := parsePodPicker(pick); err == nil {
+			t.Errorf("parsePodPicker(%q): expected an error", pick)
+		}
+	}
+
+	pods := eligiblePods([]corev1.Pod{testPod("web-a", "node-1", true, time.Now())}, false)
+	for _, pick := range []string{"index=1", "node=node-2"} {
+		picker, _ := parsePodPicker(pick)
+		if _, err := picker.pick(pods); err == nil {
+			t.Errorf("pick %s: expected an error", pick)
+		}
+	}
+}
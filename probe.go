@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// probeWriteOut is the --write-out format appended to the output of probe
+// requests, it is removed from the output before being printed.
+const probeWriteOut = "\n%{http_code} %{time_total} %{redirect_url}"
+
+// maxProbeRedirects is the number of redirects that the kubelet follows
+// before failing a probe.
+const maxProbeRedirects = 10
+
+func parseProbeType(s string) error {
+	switch s {
+	case "", "readiness", "liveness", "startup":
+		return nil
+	}
+	return fmt.Errorf("unsupported probe type: %q (expected readiness, liveness, or startup)", s)
+}
+
+// containerProbe returns the probe of the given type configured on the
+// container.
+func containerProbe(container *corev1.Container, probeType string) *corev1.Probe {
+	switch probeType {
+	case "readiness":
+		return container.ReadinessProbe
+	case "liveness":
+		return container.LivenessProbe
+	case "startup":
+		return container.StartupProbe
+	}
+	return nil
+}
+
+// selectProbe returns the container of the pod with an httpGet probe of the
+// given type. When containerName is empty and several containers have such a
+// probe, the default-container annotation selects one of them.
+func selectProbe(pod *corev1.Pod, containerName, probeType string) (*corev1.Container, *corev1.Probe, error) {
+	if containerName != "" {
+		container, ok := findContainer(pod, containerName)
+		if !ok {
+			return nil, nil, fmt.Errorf("container %s not found in pod %s", containerName, pod.Name)
+		}
+		probe := containerProbe(container, probeType)
+		if probe == nil {
+			return nil, nil, fmt.Errorf("container %s of pod %s has no %s probe", containerName, pod.Name, probeType)
+		}
+		if probe.HTTPGet == nil {
+			return nil, nil, fmt.Errorf("the %s probe of container %s in pod %s is not an httpGet probe", probeType, containerName, pod.Name)
+		}
+		return container, probe, nil
+	}
+
+	var matches []string
+	var selected *corev1.Container
+	containers := podContainers(pod)
+	for i := range containers {
+		container := &containers[i]
+		probe := containerProbe(container, probeType)
+		if probe == nil || probe.HTTPGet == nil {
+			continue
+		}
+		if selected == nil || container.Name == pod.Annotations[defaultContainerAnnotation] {
+			selected = container
+		}
+		matches = append(matches, container.Name)
+	}
+
+	switch {
+	case len(matches) == 0:
+		return nil, nil, fmt.Errorf("pod %s has no containers with an httpGet %s probe", pod.Name, probeType)
+	case len(matches) > 1 && selected.Name != pod.Annotations[defaultContainerAnnotation]:
+		return nil, nil, fmt.Errorf("pod %s has multiple containers with an httpGet %s probe (%s), use -c/--container to specify which one to replay",
+			pod.Name, probeType, strings.Join(matches, ", "))
+	}
+	return selected, containerProbe(selected, probeType), nil
+}
+
+// probePod replays the httpGet probe of the given type through a port-forward
+// to the pod of req, the same way the kubelet would send it, and reports
+// whether the kubelet would count the result as a success: a status between
+// 200 and 399 received within the timeout of the probe.
+//
+// Like the kubelet, the request does not verify TLS certificates, follows up
+// to 10 redirects to the same host and judges the final response, and counts
+// redirects to other hosts as successes without following them.
+func probePod(ctx context.Context, req curlRequest, probeType string) error {
+	pod, err := req.client.CoreV1().Pods(req.namespace).Get(ctx, req.podName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	container, probe, err := selectProbe(pod, req.containerName, probeType)
+	if err != nil {
+		return err
+	}
+	action := probe.HTTPGet
+
+	port, ok := resolveContainerPort(container, action.Port)
+	if !ok {
+		return fmt.Errorf("port %s of the %s probe is not declared by container %s", action.Port.String(), probeType, container.Name)
+	}
+	if action.Host != "" {
+		return fmt.Errorf("the %s probe of container %s is sent to host %s, not to the pod", probeType, container.Name, action.Host)
+	}
+
+	scheme := strings.ToLower(string(action.Scheme))
+	if scheme == "" {
+		scheme = "http"
+	}
+	path := action.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	timeoutSeconds := probe.TimeoutSeconds
+	if timeoutSeconds == 0 {
+		timeoutSeconds = 1
+	}
+
+	probeReq := req
+	probeReq.containerName = container.Name
+	probeReq.podPort = strconv.Itoa(int(port))
+	probeReq.requestURL.Scheme = scheme
+	probeReq.requestURL.Host = net.JoinHostPort(pod.Status.PodIP, probeReq.podPort)
+	probeReq.requestURL.Path, probeReq.requestURL.RawQuery, _ = strings.Cut(path, "?")
+
+	// The kubelet sends these headers unless the probe overrides them.
+	headers := map[string]string{"user-agent": "kube-probe", "accept": "*/*"}
+	for _, h := range action.HTTPHeaders {
+		delete(headers, strings.ToLower(h.Name))
+	}
+	args := make([]string, 0, len(req.args)+2*len(action.HTTPHeaders)+10)
+	args = append(args, req.args...)
+	for _, name := range []string{"user-agent", "accept"} {
+		if value, ok := headers[name]; ok {
+			args = append(args, "--header", name+": "+value)
+		}
+	}
+	for _, h := range action.HTTPHeaders {
+		args = append(args, "--header", h.Name+": "+h.Value)
+	}
+	args = append(args, "--insecure", "--write-out", probeWriteOut)
+
+	_, _ = fmt.Fprintf(req.stderr, "* Replaying the %s probe of container %s in pod/%s: GET %s (timeout %ds)\n",
+		probeType, container.Name, pod.Name, probeReq.requestURL.String(), timeoutSeconds)
+
+	// The timeout of the probe applies to the whole request, including the
+	// redirects which are followed.
+	timeout := time.Duration(timeoutSeconds) * time.Second
+	var elapsed time.Duration
+	for redirects := 0; ; redirects++ {
+		output := new(bytes.Buffer)
+		probeReq.stdout = output
+		probeReq.args = append(args[:len(args):len(args)], "--max-time", strconv.FormatFloat((timeout-elapsed).Seconds(), 'f', 3, 64))
+
+		err = curlPod(ctx, probeReq)
+
+		body, result := parseProbeOutput(output.Bytes())
+		if curlExitCode(err) == curlTimeoutExitCode {
+			_, _ = fmt.Fprintf(req.stderr, "* Probe failure: no response within %ds\n", timeoutSeconds)
+			return fmt.Errorf("%s probe failed", probeType)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(req.stderr, "* Probe failure: %s\n", err)
+			return fmt.Errorf("%s probe failed", probeType)
+		}
+		if result.err != nil {
+			return fmt.Errorf("unable to read the result of the %s probe: %w", probeType, result.err)
+		}
+		elapsed += result.totalTime
+
+		next, sameHost := nextProbeURL(&probeReq.requestURL, result)
+		if next != nil && sameHost {
+			if redirects == maxProbeRedirects {
+				_, _ = fmt.Fprintf(req.stderr, "* Probe failure: stopped after %d redirects\n", maxProbeRedirects)
+				return fmt.Errorf("%s probe failed", probeType)
+			}
+			if elapsed >= timeout {
+				_, _ = fmt.Fprintf(req.stderr, "* Probe failure: no response within %ds\n", timeoutSeconds)
+				return fmt.Errorf("%s probe failed", probeType)
+			}
+			_, _ = fmt.Fprintf(req.stderr, "* HTTP %d, following the redirect to %s\n", result.statusCode, next)
+			probeReq.requestURL = *next
+			probeReq.podPort = next.Port()
+			if probeReq.podPort == "" {
+				probeReq.podPort = strconv.Itoa(int(wellKnownPorts[next.Scheme]))
+			}
+			continue
+		}
+
+		_, _ = req.stdout.Write(body)
+		if len(body) != 0 && !bytes.HasSuffix(body, []byte("\n")) {
+			_, _ = fmt.Fprintln(req.stdout)
+		}
+		if result.statusCode < 200 || result.statusCode >= 400 {
+			_, _ = fmt.Fprintf(req.stderr, "* Probe failure: HTTP %d in %.3fs\n", result.statusCode, elapsed.Seconds())
+			return fmt.Errorf("%s probe failed", probeType)
+		}
+		if next != nil {
+			_, _ = fmt.Fprintf(req.stderr, "* Probe success: HTTP %d in %.3fs, the redirect to %s is not followed because it is on another host\n",
+				result.statusCode, elapsed.Seconds(), next)
+			return nil
+		}
+		_, _ = fmt.Fprintf(req.stderr, "* Probe success: HTTP %d in %.3fs\n", result.statusCode, elapsed.Seconds())
+		return nil
+	}
+}
+
+// probeResult is the result of a probe request, read from the output of
+// probeWriteOut.
+type probeResult struct {
+	statusCode  int
+	totalTime   time.Duration
+	redirectURL string
+	err         error
+}
+
+// parseProbeOutput splits the output of a probe request into the response
+// body and the result written by probeWriteOut.
+func parseProbeOutput(output []byte) ([]byte, probeResult) {
+	body, line := output, ""
+	if i := bytes.LastIndexByte(output, '\n'); i >= 0 {
+		body, line = output[:i], string(output[i+1:])
+	}
+
+	var result probeResult
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 {
+		result.err = fmt.Errorf("unexpected result %q", line)
+		return body, result
+	}
+	statusCode, err := strconv.Atoi(fields[0])
+	if err != nil {
+		result.err = fmt.Errorf("unexpected status code %q", fields[0])
+		return body, result
+	}
+	totalTime, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		result.err = fmt.Errorf("unexpected total time %q", fields[1])
+		return body, result
+	}
+	result.statusCode = statusCode
+	result.totalTime = time.Duration(totalTime * float64(time.Second))
+	result.redirectURL = fields[2]
+	return body, result
+}
+
+// nextProbeURL returns the URL that the response of a probe request redirects
+// to, if any, and whether the kubelet would follow it: only redirects to the
+// host of the current URL are followed, regardless of the port and scheme.
+func nextProbeURL(current *url.URL, result probeResult) (*url.URL, bool) {
+	if result.statusCode < 300 || result.statusCode >= 400 || result.redirectURL == "" {
+		return nil, false
+	}
+	next, err := current.Parse(result.redirectURL)
+	if err != nil {
+		return nil, false
+	}
+	return next, next.Hostname() == current.Hostname()
+}
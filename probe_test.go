@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestSelectProbe(t *testing.T) {
+	httpGet := &corev1.Probe{ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/ready", Port: intstr.FromInt(8080)}}}
+	exec := &corev1.Probe{ProbeHandler: corev1.ProbeHandler{Exec: &corev1.ExecAction{Command: []string{"true"}}}}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "app", ReadinessProbe: httpGet, LivenessProbe: httpGet},
+			{Name: "proxy", ReadinessProbe: httpGet, StartupProbe: exec},
+		}},
+	}
+
+	if _, _, err := selectProbe(pod, "", "readiness"); err == nil {
+		t.Error("expected an error when multiple containers have the probe")
+	}
+
+	container, probe, err := selectProbe(pod, "", "liveness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.Name != "app" || probe != httpGet {
+		t.Errorf("wrong probe selected: container=%s", container.Name)
+	}
+
+	if _, _, err := selectProbe(pod, "proxy", "startup"); err == nil {
+		t.Error("expected an error for a probe which is not an httpGet probe")
+	}
+
+	pod.Annotations = map[string]string{defaultContainerAnnotation: "proxy"}
+	container, _, err = selectProbe(pod, "", "readiness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.Name != "proxy" {
+		t.Errorf("default container not selected: container=%s", container.Name)
+	}
+}
+
+func TestParseProbeOutput(t *testing.T) {
+	body, result := parseProbeOutput([]byte("ok\n302 0.012000 http://10.0.0.1:8080/login"))
+	if string(body) != "ok" || result.err != nil {
+		t.Fatalf("got body %q, error %v", body, result.err)
+	}
+	if result.statusCode != 302 || result.totalTime != 12*time.Millisecond || result.redirectURL != "http://10.0.0.1:8080/login" {
+		t.Errorf("got %+v", result)
+	}
+
+	if _, result := parseProbeOutput([]byte("\n200 0.001000 ")); result.err != nil || result.redirectURL != "" {
+		t.Errorf("no redirect: got %+v", result)
+	}
+	if _, result := parseProbeOutput([]byte("curl: (7) failed")); result.err == nil {
+		t.Error("expected an error for an output without result")
+	}
+}
+
+func TestNextProbeURL(t *testing.T) {
+	current, _ := url.Parse("http://10.0.0.1:8080/ready")
+	tests := []struct {
+		statusCode  int
+		redirectURL string
+		next        string
+		sameHost    bool
+	}{
+		{statusCode: 200, next: ""},
+		{statusCode: 302, redirectURL: "/login", next: "http://10.0.0.1:8080/login", sameHost: true},
+		{statusCode: 301, redirectURL: "https://10.0.0.1/ready", next: "https://10.0.0.1/ready", sameHost: true},
+		{statusCode: 302, redirectURL: "http://auth.example.com/login", next: "http://auth.example.com/login", sameHost: false},
+		{statusCode: 304, next: ""},
+	}
+	for _, test := range tests {
+		next, sameHost := nextProbeURL(current, probeResult{statusCode: test.statusCode, redirectURL: test.redirectURL})
+		got := ""
+		if next != nil {
+			got = next.String()
+		}
+		if got != test.next || sameHost != test.sameHost {
+			t.Errorf("%d %q: got %q (same host %t), want %q (same host %t)", test.statusCode, test.redirectURL, got, sameHost, test.next, test.sameHost)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// apiserverProxyURL returns the URL of the proxy subresource of a pod or a
+// service, which forwards requests to the port of the pod or the service:
+//
+//	/api/v1/namespaces/NAMESPACE/{pods,services}/[https:]NAME[:PORT]/proxy
+//
+// The port may be empty for services, the first port of the service is then
+// used by the API server.
+func apiserverProxyURL(client kubernetes.Interface, namespace, resource, name, scheme, port string) *url.URL {
+	target := name
+	if scheme == "https" {
+		target = "https:" + target + ":" + port
+	} else if port != "" {
+		target += ":" + port
+	}
+	return client.CoreV1().RESTClient().Get().
+		AbsPath("/api/v1/namespaces", namespace, resource, target, "proxy").
+		URL()
+}
+
+// apiserverProxyTransport sends requests to proxyURL with the credentials of
+// the kubeconfig, the path of the requests is appended to the path of the
+// proxy subresource.
+//
+// The Authorization header of the requests is removed, since the API server
+// authenticates requests with this header.
+type apiserverProxyTransport struct {
+	transport http.RoundTripper
+	proxyURL  *url.URL
+	stderr    io.Writer
+	warnOnce  sync.Once
+}
+
+func newAPIServerProxyTransport(config *rest.Config, proxyURL *url.URL, stderr io.Writer) (*apiserverProxyTransport, error) {
+	transport, err := rest.TransportFor(config)
+	if err != nil {
+		return nil, err
+	}
+	return &apiserverProxyTransport{transport: transport, proxyURL: proxyURL, stderr: stderr}, nil
+}
+
+func (t *apiserverProxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	out := req.Clone(req.Context())
+	out.URL.Scheme = t.proxyURL.Scheme
+	out.URL.Host = t.proxyURL.Host
+	out.URL.Path = strings.TrimSuffix(t.proxyURL.Path, "/") + req.URL.Path
+	out.URL.RawPath = ""
+	out.Host = ""
+	if out.Header.Get("Authorization") != "" {
+		t.warnOnce.Do(func() {
+			_, _ = fmt.Fprintln(t.stderr, "* WARNING: the Authorization header cannot be sent through the API server proxy, it was removed")
+		})
+		out.Header.Del("Authorization")
+	}
+	return t.transport.RoundTrip(out)
+}
+
+// startAPIServerProxy starts a local HTTP server which sends the requests it
+// receives with transport, and returns its port. The curl binary is pointed
+// at this server so the requests sent through the API server are built from
+// the same options as the port forwarded ones.
+//
+// The server only listens on the loopback interface, for the duration of a
+// single request of the curl binary; the native executor sends its requests
+// with the transport directly.
+func startAPIServerProxy(transport *apiserverProxyTransport) (int32, func(), error) {
+	proxy := &httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite: func(r *httputil.ProxyRequest) {
+			// The URL is rewritten by the transport.
+			r.Out.Host = ""
+		},
+		ErrorLog: log.Default(),
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to listen for the API server proxy: %w", err)
+	}
+	server := &http.Server{Handler: proxy}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Print(err)
+		}
+	}()
+	return int32(listener.Addr().(*net.TCPAddr).Port), func() { server.Close() }, nil
+}
+
+// curlThroughAPIServer sends the request of req to proxyURL. The native
+// executor sends it with the credentials of the kubeconfig directly, while the
+// curl binary is pointed at a local API server proxy.
+func curlThroughAPIServer(ctx context.Context, req curlRequest, proxyURL *url.URL) error {
+	transport, err := newAPIServerProxyTransport(req.config, proxyURL, req.stderr)
+	if err != nil {
+		return err
+	}
+
+	requestURL := req.requestURL
+	args := make([]string, 0, len(req.args)+4)
+	args = append(args, req.args...)
+	// See curlPod for why --silent is always set.
+	nativeArgs := append(args[:len(args):len(args)], requestURL.String(), "--silent")
+	n, err := nativeCurlFor(req, nativeArgs)
+	if err != nil {
+		return err
+	}
+	if n != nil {
+		n.transport = transport
+		log.Printf("native curl %s, through %s", prettyArgs(nativeArgs), proxyURL)
+		return n.run(ctx, req.stdout, req.stderr)
+	}
+
+	port, stop, err := startAPIServerProxy(transport)
+	if err != nil {
+		return err
+	}
+	defer stop()
+	log.Printf("proxying local port %d to %s", port, proxyURL)
+
+	// The local proxy only serves plain HTTP, the scheme of the URL is given
+	// to the API server in the proxy URL instead.
+	requestURL.Scheme = "http"
+	args = append(args, "--connect-to", connectTo(requestURL.Hostname(), "127.0.0.1", port))
+	args = append(args, requestURL.String(), "--silent")
+	return execCurl(ctx, req, args)
+}
+
+// curlServiceThroughAPIServer sends the request of req to the service of req
+// through the services/proxy subresource.
+func curlServiceThroughAPIServer(ctx context.Context, req curlRequest) error {
+	proxyURL := apiserverProxyURL(req.client, req.namespace, "services", req.serviceName, req.requestURL.Scheme, req.podPort)
+	if debug || isVerbose(req.args) {
+		_, _ = fmt.Fprintf(req.stderr, "Sending the request to service/%s through %s\n", req.serviceName, proxyURL.Path)
+	}
+	return curlThroughAPIServer(ctx, req, proxyURL)
+}
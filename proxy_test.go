@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestAPIServerProxyURL(t *testing.T) {
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: "https://cluster.example:6443"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		resource string
+		name     string
+		scheme   string
+		port     string
+		path     string
+	}{
+		{"pods", "mypod", "http", "8080", "/api/v1/namespaces/ns/pods/mypod:8080/proxy"},
+		{"pods", "mypod", "https", "8443", "/api/v1/namespaces/ns/pods/https:mypod:8443/proxy"},
+		{"services", "mysvc", "http", "", "/api/v1/namespaces/ns/services/mysvc/proxy"},
+		{"services", "mysvc", "http", "http-metrics", "/api/v1/namespaces/ns/services/mysvc:http-metrics/proxy"},
+		{"services", "mysvc", "https", "", "/api/v1/namespaces/ns/services/https:mysvc:/proxy"},
+	}
+
+	for _, test := range tests {
+		u := apiserverProxyURL(client, "ns", test.resource, test.name, test.scheme, test.port)
+		if u.Host != "cluster.example:6443" || u.Path != test.path {
+			t.Errorf("%s/%s (%s, %q): got %s, want %s", test.resource, test.name, test.scheme, test.port, u, test.path)
+		}
+	}
+}
+
+func TestStartAPIServerProxy(t *testing.T) {
+	apiserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s auth=%s x-test=%s body=%s", r.Method, r.URL.RequestURI(), r.Header.Get("Authorization"), r.Header.Get("X-Test"), body)
+	}))
+	defer apiserver.Close()
+
+	proxyURL, _ := url.Parse(apiserver.URL + "/api/v1/namespaces/ns/pods/mypod:8080/proxy")
+	stderr := new(strings.Builder)
+	transport, err := newAPIServerProxyTransport(&rest.Config{Host: apiserver.URL, BearerToken: "kubeconfig-token"}, proxyURL, stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, stop, err := startAPIServerProxy(transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/v1/items?limit=1", port), strings.NewReader("hello"))
+	req.Header.Set("Authorization", "Bearer pod-token")
+	req.Header.Set("X-Test", "yes")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+
+	const want = "POST /api/v1/namespaces/ns/pods/mypod:8080/proxy/v1/items?limit=1 auth=Bearer kubeconfig-token x-test=yes body=hello"
+	if string(body) != want {
+		t.Errorf("got %q, want %q", body, want)
+	}
+	if stderr.String() == "" {
+		t.Error("no warning printed about the removed Authorization header")
+	}
+}
+
+func TestAPIServerProxyTransportNative(t *testing.T) {
+	apiserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s host=%s auth=%s", r.Method, r.URL.RequestURI(), r.Host, r.Header.Get("Authorization"))
+	}))
+	defer apiserver.Close()
+
+	proxyURL, _ := url.Parse(apiserver.URL + "/api/v1/namespaces/ns/services/web:http/proxy")
+	stderr := new(strings.Builder)
+	transport, err := newAPIServerProxyTransport(&rest.Config{Host: apiserver.URL, BearerToken: "kubeconfig-token"}, proxyURL, stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The native executor sends the requests with the transport, without
+	// connecting to the host of the URL.
+	n, err := parseNativeArgs([]string{"--header", "Authorization: Bearer pod-token", "http://web.ns.svc/healthz?v=1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.transport = transport
+	stdout := new(strings.Builder)
+	if err := n.run(context.Background(), stdout, stderr); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "GET /api/v1/namespaces/ns/services/web:http/proxy/healthz?v=1 host=" + proxyURL.Host + " auth=Bearer kubeconfig-token"
+	if stdout.String() != want {
+		t.Errorf("got %q, want %q", stdout, want)
+	}
+	if stderr.String() == "" {
+		t.Error("no warning printed about the removed Authorization header")
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+var (
+	podsResource     = schema.GroupResource{Resource: "pods"}
+	servicesResource = schema.GroupResource{Resource: "services"}
+	cronJobsResource = schema.GroupResource{Group: "batch", Resource: "cronjobs"}
+)
+
+// podResolver resolves resource references given on the command line to the
+// pods that back them. Resource types are looked up with the cluster's
+// discovery RESTMapper, so any kind known to kubectl is supported, including
+// short names and categories.
+type podResolver struct {
+	client     kubernetes.Interface
+	dynamic    dynamic.Interface
+	mapper     meta.RESTMapper
+	categories restmapper.CategoryExpander
+	namespace  string
+}
+
+// newPodResolver initializes the kubernetes clients for the context selected by
+// config, and returns a resolver for the pods of its namespace.
+func newPodResolver(config *genericclioptions.ConfigFlags) (*podResolver, *rest.Config, error) {
+	namespace, _, err := config.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return nil, nil, err
+	}
+	restConfig, err := config.ToRESTConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	discoveryClient, err := config.ToDiscoveryClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	// Unlike the deferred mapper of kubectl, this mapper does not reset the
+	// discovery cache when a type is not found, which happens for every pod
+	// name that URLs are checked against.
+	mapper := meta.NewLazyRESTMapperLoader(func() (meta.RESTMapper, error) {
+		groupResources, err := restmapper.GetAPIGroupResources(discoveryClient)
+		if err != nil {
+			return nil, err
+		}
+		return restmapper.NewShortcutExpander(restmapper.NewDiscoveryRESTMapper(groupResources), discoveryClient, nil), nil
+	})
+	resolver := &podResolver{
+		client:     client,
+		dynamic:    dynamicClient,
+		mapper:     mapper,
+		categories: restmapper.NewDiscoveryCategoryExpander(discoveryClient),
+		namespace:  namespace,
+	}
+	return resolver, restConfig, nil
+}
+
+// lookupResourceType reports whether resourceType names a kind of resource or
+// a category known to the cluster. It is used to tell resource references
+// apart from pod names when parsing URLs.
+func (r *podResolver) lookupResourceType(resourceType string) (string, bool) {
+	if !isResourceTypeName(resourceType) {
+		return "", false
+	}
+	resources, err := r.resourcesFor(resourceType)
+	if err != nil || len(resources) == 0 {
+		return "", false
+	}
+	return strings.ToLower(resourceType), true
+}
+
+// isResourceTypeName reports whether s has the syntax of a resource type, a
+// short name, or a "resource.group" name. Hosts with a port and IPs do not,
+// so they are not looked up.
+func isResourceTypeName(s string) bool {
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// resourcesFor returns the resources that resourceType refers to. The type may
+// be a resource name in plural or singular form, a kind, a short name, a
+// fully qualified "resource.group" name, or a category that expands to several
+// resources.
+func (r *podResolver) resourcesFor(resourceType string) ([]schema.GroupVersionResource, error) {
+	resourceType = strings.ToLower(resourceType)
+
+	gvr, err := r.mapper.ResourceFor(schema.ParseGroupResource(resourceType).WithVersion(""))
+	if err == nil {
+		return []schema.GroupVersionResource{gvr}, nil
+	}
+
+	if r.categories != nil {
+		if groupResources, ok := r.categories.Expand(resourceType); ok {
+			resources := make([]schema.GroupVersionResource, 0, len(groupResources))
+			for _, gr := range groupResources {
+				gvr, err := r.mapper.ResourceFor(gr.WithVersion(""))
+				if err != nil {
+					continue
+				}
+				resources = append(resources, gvr)
+			}
+			if len(resources) != 0 {
+				return resources, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported resource type: %s", resourceType)
+}
+
+// isServiceType reports whether resourceType refers to core services, which are
+// resolved through their endpoints rather than through their selector.
+func (r *podResolver) isServiceType(resourceType string) bool {
+	resources, err := r.resourcesFor(resourceType)
+	return err == nil && len(resources) == 1 && resources[0].GroupResource() == servicesResource
+}
+
+// resolveObject fetches the object of the given type and name. When the type is
+// a category, the first resource of the category with an object of that name
+// is used.
+func (r *podResolver) resolveObject(ctx context.Context, resourceType, resourceName string) (*unstructured.Unstructured, schema.GroupVersionResource, error) {
+	resources, err := r.resourcesFor(resourceType)
+	if err != nil {
+		return nil, schema.GroupVersionResource{}, err
+	}
+
+	for _, gvr := range resources {
+		obj, err := r.dynamic.Resource(gvr).Namespace(r.namespace).Get(ctx, resourceName, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) && len(resources) > 1 {
+				continue
+			}
+			return nil, gvr, fmt.Errorf("failed to get %s %s: %w", gvr.GroupResource(), resourceName, err)
+		}
+		return obj, gvr, nil
+	}
+
+	return nil, schema.GroupVersionResource{}, fmt.Errorf("no resource of type %s named %s", resourceType, resourceName)
+}
+
+// resolvePodsFromResource finds the pods for a given resource type and name in a namespace.
+func (r *podResolver) resolvePodsFromResource(ctx context.Context, resourceType, resourceName string) ([]corev1.Pod, error) {
+	obj, gvr, err := r.resolveObject(ctx, resourceType, resourceName)
+	if err != nil {
+		return nil, err
+	}
+
+	if gvr.GroupResource() == podsResource {
+		pod, err := r.client.CoreV1().Pods(r.namespace).Get(ctx, resourceName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return []corev1.Pod{*pod}, nil
+	}
+
+	if gvr.GroupResource() == cronJobsResource {
+		return r.resolvePodsFromCronJob(ctx, obj)
+	}
+
+	selector, err := r.selectorFor(ctx, obj, gvr)
+	if err != nil {
+		return nil, err
+	}
+
+	kind := strings.ToLower(obj.GetKind())
+	podsList, err := r.client.CoreV1().Pods(r.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods for %s %s: %w", kind, resourceName, err)
+	}
+	if len(podsList.Items) == 0 {
+		return nil, fmt.Errorf("no pods found for %s %s", kind, resourceName)
+	}
+	return podsList.Items, nil
+}
+
+// resolvePodsFromCronJob finds the pods of the jobs owned by a CronJob, which
+// has no selector of its own.
+func (r *podResolver) resolvePodsFromCronJob(ctx context.Context, cronJob *unstructured.Unstructured) ([]corev1.Pod, error) {
+	jobsList, err := r.client.BatchV1().Jobs(r.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs of cronjob %s: %w", cronJob.GetName(), err)
+	}
+
+	var pods []corev1.Pod
+	for _, job := range jobsList.Items {
+		owner := metav1.GetControllerOf(&job)
+		if owner == nil || owner.Kind != "CronJob" || owner.UID != cronJob.GetUID() {
+			continue
+		}
+		if job.Spec.Selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(job.Spec.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector of job %s: %w", job.Name, err)
+		}
+		podsList, err := r.client.CoreV1().Pods(r.namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: selector.String(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods for job %s: %w", job.Name, err)
+		}
+		pods = append(pods, podsList.Items...)
+	}
+
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("no pods found for the jobs of cronjob %s", cronJob.GetName())
+	}
+	return pods, nil
+}
+
+// resolvePodsFromSelector lists the pods matching the label and field selectors.
+func (r *podResolver) resolvePodsFromSelector(ctx context.Context, labelSelector, fieldSelector string) ([]corev1.Pod, error) {
+	source := selectorString(labelSelector, fieldSelector)
+
+	podsList, err := r.client.CoreV1().Pods(r.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labelSelector,
+		FieldSelector: fieldSelector,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods for %s: %w", source, err)
+	}
+	if len(podsList.Items) == 0 {
+		return nil, fmt.Errorf("no pods found for %s", source)
+	}
+	return podsList.Items, nil
+}
+
+// resolvePodFromIP finds the pod which has the given IP, looking for it in all
+// namespaces when allNamespaces is true and the user is allowed to. Pods that
+// have completed are ignored, since their IP may have been reused.
+func (r *podResolver) resolvePodFromIP(ctx context.Context, ip string, allNamespaces bool) (*corev1.Pod, error) {
+	options := metav1.ListOptions{
+		FieldSelector: "status.podIP=" + ip,
+	}
+
+	namespace := r.namespace
+	if allNamespaces {
+		namespace = metav1.NamespaceAll
+	}
+
+	podsList, err := r.client.CoreV1().Pods(namespace).List(ctx, options)
+	if err != nil && allNamespaces && apierrors.IsForbidden(err) {
+		namespace = r.namespace
+		podsList, err = r.client.CoreV1().Pods(namespace).List(ctx, options)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods with IP %s: %w", ip, err)
+	}
+
+	for i, pod := range podsList.Items {
+		if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+			return &podsList.Items[i], nil
+		}
+	}
+
+	if namespace == metav1.NamespaceAll {
+		return nil, fmt.Errorf("no running pod found with IP %s", ip)
+	}
+	return nil, fmt.Errorf("no running pod found with IP %s in namespace %s", ip, namespace)
+}
+
+func selectorString(labelSelector, fieldSelector string) string {
+	selectors := make([]string, 0, 2)
+	if labelSelector != "" {
+		selectors = append(selectors, "selector="+labelSelector)
+	}
+	if fieldSelector != "" {
+		selectors = append(selectors, "field-selector="+fieldSelector)
+	}
+	return strings.Join(selectors, ", ")
+}
+
+// selectorFor returns the pod selector of obj, read from .spec.selector or, for
+// resources which do not expose one, from the status of the scale subresource.
+// This mirrors how kubectl finds the attachable pods of a resource.
+func (r *podResolver) selectorFor(ctx context.Context, obj *unstructured.Unstructured, gvr schema.GroupVersionResource) (labels.Selector, error) {
+	kind := strings.ToLower(obj.GetKind())
+
+	if value, found, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec", "selector"); found {
+		selector, err := parseSelector(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector of %s %s: %w", kind, obj.GetName(), err)
+		}
+		if !selector.Empty() {
+			return selector, nil
+		}
+	}
+
+	scale, err := r.dynamic.Resource(gvr).Namespace(r.namespace).Get(ctx, obj.GetName(), metav1.GetOptions{}, "scale")
+	if err == nil {
+		if value, found, _ := unstructured.NestedString(scale.Object, "status", "selector"); found && value != "" {
+			selector, err := labels.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid scale selector of %s %s: %w", kind, obj.GetName(), err)
+			}
+			return selector, nil
+		}
+	}
+
+	return nil, fmt.Errorf("cannot find the pods of %s %s because it has no selector", kind, obj.GetName())
+}
+
+// parseSelector converts the value of a .spec.selector field to a label
+// selector. Most workloads use a metav1.LabelSelector, while older kinds such
+// as services and replication controllers use a plain map of labels.
+func parseSelector(value interface{}) (labels.Selector, error) {
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected selector type %T", value)
+	}
+
+	_, hasMatchLabels := fields["matchLabels"]
+	_, hasMatchExpressions := fields["matchExpressions"]
+	if hasMatchLabels || hasMatchExpressions {
+		labelSelector := new(metav1.LabelSelector)
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(fields, labelSelector); err != nil {
+			return nil, err
+		}
+		return metav1.LabelSelectorAsSelector(labelSelector)
+	}
+
+	set := make(labels.Set, len(fields))
+	for key, value := range fields {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected value type %T for label %s", value, key)
+		}
+		set[key] = s
+	}
+	return labels.SelectorFromSet(set), nil
+}
+
+// resolvePodsFromService finds the endpoint pods backing the service and the
+// target port that the service port maps to on each of those pods, by pod name.
+// The target ports may differ between pods when the service targets a named
+// port, during a rollout changing its number for example. The service port
+// may be given by name or number; when empty, the port named after the URL
+// scheme is used, or the only port of the service if it declares just one.
+//
+// Endpoints which are not ready are returned as well, so the pods go through
+// the same eligibility rules and pickers as the pods of other resources.
+func (r *podResolver) resolvePodsFromService(ctx context.Context, serviceName, servicePort, scheme string) ([]corev1.Pod, map[string]int32, error) {
+	service, err := r.client.CoreV1().Services(r.namespace).Get(ctx, serviceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get service %s: %w", serviceName, err)
+	}
+
+	port, err := selectServicePort(service, servicePort, scheme)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slices, err := r.client.DiscoveryV1().EndpointSlices(r.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName + "=" + serviceName,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list endpoint slices for service %s: %w", serviceName, err)
+	}
+
+	targetPorts := make(map[string]int32)
+
+	for _, slice := range slices.Items {
+		var slicePort int32
+		for _, p := range slice.Ports {
+			if p.Name != nil && *p.Name == port.Name && p.Port != nil &&
+				(p.Protocol == nil || *p.Protocol == corev1.ProtocolTCP) {
+				slicePort = *p.Port
+				break
+			}
+		}
+		if slicePort == 0 {
+			continue
+		}
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.TargetRef == nil || endpoint.TargetRef.Kind != "Pod" {
+				continue
+			}
+			if _, ok := targetPorts[endpoint.TargetRef.Name]; !ok {
+				targetPorts[endpoint.TargetRef.Name] = slicePort
+			}
+		}
+	}
+
+	if len(targetPorts) == 0 {
+		return nil, nil, fmt.Errorf("no endpoints found for port %s of service %s", servicePortName(port), serviceName)
+	}
+
+	// The pods are listed with the selector of the service, which selects
+	// all of its endpoint pods unless the endpoints are managed manually.
+	podsList, err := r.client.CoreV1().Pods(r.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(service.Spec.Selector).String(),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list pods for service %s: %w", serviceName, err)
+	}
+	var pods []corev1.Pod
+	for _, pod := range podsList.Items {
+		if _, ok := targetPorts[pod.Name]; ok {
+			pods = append(pods, pod)
+		}
+	}
+	if len(pods) == 0 {
+		return nil, nil, fmt.Errorf("no pods found for the endpoints of port %s of service %s", servicePortName(port), serviceName)
+	}
+	return pods, targetPorts, nil
+}
+
+// targetPortsString returns the distinct target ports of the pods in
+// increasing order, separated by commas.
+func targetPortsString(pods []corev1.Pod, targetPorts map[string]int32) string {
+	seen := make(map[int32]bool)
+	var ports []int
+	for _, pod := range pods {
+		if port := targetPorts[pod.Name]; !seen[port] {
+			seen[port] = true
+			ports = append(ports, int(port))
+		}
+	}
+	sort.Ints(ports)
+	s := make([]string, len(ports))
+	for i, port := range ports {
+		s[i] = strconv.Itoa(port)
+	}
+	return strings.Join(s, ", ")
+}
+
+func selectServicePort(service *corev1.Service, servicePort, scheme string) (corev1.ServicePort, error) {
+	ports := service.Spec.Ports
+
+	if servicePort == "" {
+		for _, port := range ports {
+			if port.Name == scheme && port.Protocol == corev1.ProtocolTCP {
+				return port, nil
+			}
+		}
+		if len(ports) == 1 && ports[0].Protocol == corev1.ProtocolTCP {
+			return ports[0], nil
+		}
+		return corev1.ServicePort{}, fmt.Errorf("service %s has no %s port, specify one with svc/%s:PORT", service.Name, scheme, service.Name)
+	}
+
+	number, err := strconv.ParseInt(servicePort, 10, 32)
+	for _, port := range ports {
+		if port.Protocol != corev1.ProtocolTCP {
+			continue
+		}
+		if (err == nil && port.Port == int32(number)) || (err != nil && port.Name == servicePort) {
+			return port, nil
+		}
+	}
+	return corev1.ServicePort{}, fmt.Errorf("service %s has no TCP port %s", service.Name, servicePort)
+}
+
+func servicePortName(port corev1.ServicePort) string {
+	if port.Name != "" {
+		return port.Name
+	}
+	return strconv.Itoa(int(port.Port))
+}
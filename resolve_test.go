@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/restmapper"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var (
+	deploymentsResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	statefulSetResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}
+	cronJobResource     = schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}
+	certificateResource = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+)
+
+// countingMapper counts the lookups of resource types.
+type countingMapper struct {
+	meta.RESTMapper
+	lookups int
+}
+
+func (m *countingMapper) ResourceFor(resource schema.GroupVersionResource) (schema.GroupVersionResource, error) {
+	m.lookups++
+	return m.RESTMapper.ResourceFor(resource)
+}
+
+func newTestMapper() *countingMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Pod"), meta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Service"), meta.RESTScopeNamespace)
+	mapper.Add(deploymentsResource.GroupVersion().WithKind("Deployment"), meta.RESTScopeNamespace)
+	mapper.Add(statefulSetResource.GroupVersion().WithKind("StatefulSet"), meta.RESTScopeNamespace)
+	mapper.Add(cronJobResource.GroupVersion().WithKind("CronJob"), meta.RESTScopeNamespace)
+	mapper.Add(certificateResource.GroupVersion().WithKind("Certificate"), meta.RESTScopeNamespace)
+	return &countingMapper{RESTMapper: mapper}
+}
+
+func newTestResolver(objects []runtime.Object, unstructuredObjects ...runtime.Object) *podResolver {
+	return &podResolver{
+		client:  fake.NewSimpleClientset(objects...),
+		dynamic: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), unstructuredObjects...),
+		mapper:  newTestMapper(),
+		categories: restmapper.SimpleCategoryExpander{Expansions: map[string][]schema.GroupResource{
+			"workloads": {deploymentsResource.GroupResource(), statefulSetResource.GroupResource()},
+		}},
+		namespace: "ns",
+	}
+}
+
+func labeledPod(name string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Labels: labels}}
+}
+
+func testWorkload(gvr schema.GroupVersionResource, kind, name string, selector map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": gvr.GroupVersion().String(),
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": name, "namespace": "ns"},
+		"spec":       map[string]interface{}{},
+	}}
+	if selector != nil {
+		_ = unstructured.SetNestedField(obj.Object, selector, "spec", "selector")
+	}
+	return obj
+}
+
+func cronJobJob(name string, owner types.UID) *batchv1.Job {
+	controller := true
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "ns",
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "CronJob", Name: "nightly", UID: owner, Controller: &controller}},
+		},
+		Spec: batchv1.JobSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"job-name": name}}},
+	}
+}
+
+func testCronJob(name string, uid types.UID) *unstructured.Unstructured {
+	obj := testWorkload(cronJobResource, "CronJob", name, nil)
+	obj.SetUID(uid)
+	return obj
+}
+
+func podNames(pods []corev1.Pod) []string {
+	names := make([]string, len(pods))
+	for i, pod := range pods {
+		names[i] = pod.Name
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestIsResourceTypeName(t *testing.T) {
+	tests := map[string]bool{
+		"deploy":                       true,
+		"Deployments":                  true,
+		"certificates.cert-manager.io": true,
+		"k8s-app":                      true,
+		"":                             false,
+		"mypod:8080":                   false,
+		"10.0.0.1":                     false,
+		"[fd00::1]":                    false,
+		"-pod":                         false,
+		"my_pod":                       false,
+	}
+	for name, want := range tests {
+		if got := isResourceTypeName(name); got != want {
+			t.Errorf("%q: got %t, want %t", name, got, want)
+		}
+	}
+}
+
+func TestLookupResourceType(t *testing.T) {
+	r := newTestResolver(nil)
+	mapper := r.mapper.(*countingMapper)
+
+	for _, host := range []string{"mypod:8080", "10.0.0.1", "[fd00::1]:80"} {
+		if _, ok := r.lookupResourceType(host); ok {
+			t.Errorf("%q: unexpected resource type", host)
+		}
+	}
+	if mapper.lookups != 0 {
+		t.Errorf("hosts which cannot be resource types were looked up %d times", mapper.lookups)
+	}
+
+	if typ, ok := r.lookupResourceType("Deployments"); !ok || typ != "deployments" {
+		t.Errorf("deployments: got %q, %t", typ, ok)
+	}
+	if typ, ok := r.lookupResourceType("workloads"); !ok || typ != "workloads" {
+		t.Errorf("workloads category: got %q, %t", typ, ok)
+	}
+	if _, ok := r.lookupResourceType("mypod"); ok {
+		t.Error("mypod: unexpected resource type")
+	}
+}
+
+func TestResourcesForCategory(t *testing.T) {
+	r := newTestResolver(nil)
+	resources, err := r.resourcesFor("workloads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []schema.GroupVersionResource{deploymentsResource, statefulSetResource}
+	if !reflect.DeepEqual(resources, want) {
+		t.Errorf("got %v, want %v", resources, want)
+	}
+	if !r.isServiceType("services") || r.isServiceType("workloads") {
+		t.Error("wrong service type detection")
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+		err   bool
+	}{
+		{map[string]interface{}{"app": "web"}, "app=web", false},
+		{map[string]interface{}{"matchLabels": map[string]interface{}{"app": "web"}}, "app=web", false},
+		{map[string]interface{}{"matchExpressions": []interface{}{
+			map[string]interface{}{"key": "tier", "operator": "In", "values": []interface{}{"a", "b"}},
+		}}, "tier in (a,b)", false},
+		{map[string]interface{}{"app": int64(1)}, "", true},
+		{"app=web", "", true},
+	}
+	for _, test := range tests {
+		selector, err := parseSelector(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("%v: expected an error", test.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.value, err)
+		} else if selector.String() != test.want {
+			t.Errorf("%v: got %q, want %q", test.value, selector, test.want)
+		}
+	}
+}
+
+func TestResolvePodsFromResource(t *testing.T) {
+	r := newTestResolver(
+		[]runtime.Object{
+			labeledPod("web-1", map[string]string{"app": "web"}),
+			labeledPod("web-2", map[string]string{"app": "web", "tier": "front"}),
+			labeledPod("db-1", map[string]string{"app": "db"}),
+			labeledPod("nightly-1-abcde", map[string]string{"job-name": "nightly-1"}),
+			labeledPod("nightly-2-fghij", map[string]string{"job-name": "nightly-2"}),
+			labeledPod("manual-klmno", map[string]string{"job-name": "manual"}),
+			cronJobJob("nightly-1", "nightly-uid"),
+			cronJobJob("nightly-2", "nightly-uid"),
+			cronJobJob("manual", "other-uid"),
+		},
+		testWorkload(deploymentsResource, "Deployment", "web", map[string]interface{}{
+			"matchLabels": map[string]interface{}{"app": "web"},
+		}),
+		testWorkload(statefulSetResource, "StatefulSet", "db", map[string]interface{}{
+			"matchLabels": map[string]interface{}{"app": "db"},
+		}),
+		testWorkload(deploymentsResource, "Deployment", "empty", map[string]interface{}{
+			"matchLabels": map[string]interface{}{"app": "none"},
+		}),
+		testWorkload(deploymentsResource, "Deployment", "noselector", nil),
+		testCronJob("nightly", "nightly-uid"),
+		testCronJob("idle", "idle-uid"),
+	)
+	ctx := context.Background()
+
+	// CronJobs have no selector, their pods are those of the jobs they own.
+	pods, err := r.resolvePodsFromResource(ctx, "cronjobs", "nightly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := podNames(pods); !reflect.DeepEqual(names, []string{"nightly-1-abcde", "nightly-2-fghij"}) {
+		t.Errorf("cronjobs/nightly: got %v", names)
+	}
+	if _, err := r.resolvePodsFromResource(ctx, "cronjobs", "idle"); err == nil || !strings.Contains(err.Error(), "no pods found") {
+		t.Errorf("cronjobs/idle: got %v", err)
+	}
+
+	pods, err = r.resolvePodsFromResource(ctx, "deployments", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := podNames(pods); !reflect.DeepEqual(names, []string{"web-1", "web-2"}) {
+		t.Errorf("deployments/web: got %v", names)
+	}
+
+	// The category is expanded to the resource with an object of that name.
+	pods, err = r.resolvePodsFromResource(ctx, "workloads", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := podNames(pods); !reflect.DeepEqual(names, []string{"db-1"}) {
+		t.Errorf("workloads/db: got %v", names)
+	}
+
+	if _, err := r.resolvePodsFromResource(ctx, "deployments", "empty"); err == nil || !strings.Contains(err.Error(), "no pods found") {
+		t.Errorf("deployments/empty: got %v", err)
+	}
+	if _, err := r.resolvePodsFromResource(ctx, "deployments", "noselector"); err == nil || !strings.Contains(err.Error(), "no selector") {
+		t.Errorf("deployments/noselector: got %v", err)
+	}
+	if _, err := r.resolvePodsFromResource(ctx, "deployments", "missing"); err == nil {
+		t.Error("deployments/missing: expected an error")
+	}
+}
+
+func TestResolvePodsFromSelector(t *testing.T) {
+	pod := func(name, app, node string) runtime.Object {
+		p := labeledPod(name, map[string]string{"app": app})
+		p.Spec.NodeName = node
+		return p
+	}
+	r := newTestResolver([]runtime.Object{
+		pod("web-1", "web", "node-a"),
+		pod("web-2", "web", "node-b"),
+		pod("db-1", "db", "node-a"),
+	})
+
+	// The fake clientset ignores field selectors, they are matched against
+	// the spec.nodeName of the pods by this reactor.
+	client := r.client.(*fake.Clientset)
+	client.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		restrictions := action.(k8stesting.ListAction).GetListRestrictions()
+		obj, err := client.Tracker().List(corev1.SchemeGroupVersion.WithResource("pods"), corev1.SchemeGroupVersion.WithKind("Pod"), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		list := obj.(*corev1.PodList)
+		var items []corev1.Pod
+		for _, pod := range list.Items {
+			if restrictions.Labels.Matches(labels.Set(pod.Labels)) && restrictions.Fields.Matches(fields.Set{"spec.nodeName": pod.Spec.NodeName}) {
+				items = append(items, pod)
+			}
+		}
+		list.Items = items
+		return true, list, nil
+	})
+
+	tests := []struct {
+		scenario      string
+		labelSelector string
+		fieldSelector string
+		pods          []string
+	}{
+		{
+			scenario:      "label selector",
+			labelSelector: "app=web",
+			pods:          []string{"web-1", "web-2"},
+		},
+		{
+			scenario:      "field selector",
+			fieldSelector: "spec.nodeName=node-a",
+			pods:          []string{"db-1", "web-1"},
+		},
+		{
+			scenario:      "label and field selectors",
+			labelSelector: "app in (web, db)",
+			fieldSelector: "spec.nodeName=node-a",
+			pods:          []string{"db-1", "web-1"},
+		},
+		{
+			scenario:      "label and field selectors matching a single pod",
+			labelSelector: "app=web",
+			fieldSelector: "spec.nodeName!=node-a",
+			pods:          []string{"web-2"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			pods, err := r.resolvePodsFromSelector(context.Background(), test.labelSelector, test.fieldSelector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names := podNames(pods); !reflect.DeepEqual(names, test.pods) {
+				t.Errorf("pods mismatch: want=%v got=%v", test.pods, names)
+			}
+		})
+	}
+
+	_, err := r.resolvePodsFromSelector(context.Background(), "app=web", "spec.nodeName=node-c")
+	if want := "no pods found for selector=app=web, field-selector=spec.nodeName=node-c"; err == nil || err.Error() != want {
+		t.Errorf("error mismatch: want=%q got=%v", want, err)
+	}
+}
+
+func TestResolvePodsFromService(t *testing.T) {
+	ready, notReady := true, false
+	portName, port, newPort, protocol := "http", int32(8080), int32(8081), corev1.ProtocolTCP
+	endpoint := func(name string, ready *bool) discoveryv1.Endpoint {
+		return discoveryv1.Endpoint{
+			Conditions: discoveryv1.EndpointConditions{Ready: ready},
+			TargetRef:  &corev1.ObjectReference{Kind: "Pod", Name: name},
+		}
+	}
+	web := map[string]string{"app": "web"}
+	r := newTestResolver([]runtime.Object{
+		labeledPod("web-1", web),
+		labeledPod("web-2", web),
+		labeledPod("web-3", web),
+		labeledPod("web-4", web),
+		labeledPod("db-1", map[string]string{"app": "db"}),
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns"},
+			Spec: corev1.ServiceSpec{
+				Selector: web,
+				Ports: []corev1.ServicePort{
+					{Name: "http", Port: 80, TargetPort: intstr.FromString("http"), Protocol: corev1.ProtocolTCP},
+					{Name: "metrics", Port: 9090, Protocol: corev1.ProtocolTCP},
+				},
+			},
+		},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-abc", Namespace: "ns", Labels: map[string]string{discoveryv1.LabelServiceName: "web"}},
+			Ports:      []discoveryv1.EndpointPort{{Name: &portName, Port: &port, Protocol: &protocol}},
+			Endpoints:  []discoveryv1.Endpoint{endpoint("web-1", &ready), endpoint("web-2", &notReady), endpoint("web-3", nil)},
+		},
+		// The pods of a rollout changing the number of the named target
+		// port are in another slice.
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{Name: "web-def", Namespace: "ns", Labels: map[string]string{discoveryv1.LabelServiceName: "web"}},
+			Ports:      []discoveryv1.EndpointPort{{Name: &portName, Port: &newPort, Protocol: &protocol}},
+			Endpoints:  []discoveryv1.Endpoint{endpoint("web-4", &ready)},
+		},
+	})
+	ctx := context.Background()
+
+	// Endpoints which are not ready are returned, the pods are picked from
+	// them with the eligibility rules of other resources.
+	pods, targetPorts, err := r.resolvePodsFromService(ctx, "web", "", "http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := podNames(pods); !reflect.DeepEqual(names, []string{"web-1", "web-2", "web-3", "web-4"}) {
+		t.Errorf("got %v, want [web-1 web-2 web-3 web-4]", names)
+	}
+	want := map[string]int32{"web-1": 8080, "web-2": 8080, "web-3": 8080, "web-4": 8081}
+	if !reflect.DeepEqual(targetPorts, want) {
+		t.Errorf("target ports mismatch: want=%v got=%v", want, targetPorts)
+	}
+	if s := targetPortsString(pods, targetPorts); s != "8080, 8081" {
+		t.Errorf("target ports string mismatch: want=%q got=%q", "8080, 8081", s)
+	}
+
+	if _, _, err := r.resolvePodsFromService(ctx, "web", "metrics", "http"); err == nil || !strings.Contains(err.Error(), "no endpoints") {
+		t.Errorf("port without endpoints: got %v", err)
+	}
+	if _, _, err := r.resolvePodsFromService(ctx, "web", "", "https"); err == nil {
+		t.Error("missing https port: expected an error")
+	}
+	if _, _, err := r.resolvePodsFromService(ctx, "missing", "", "http"); err == nil {
+		t.Error("missing service: expected an error")
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// rootCAConfigMap is the ConfigMap published in every namespace with the CA
+// bundle of the cluster.
+const rootCAConfigMap = "kube-root-ca.crt"
+
+// objectKeyRef references a key of a secret, a ConfigMap, or of the secret of
+// a cert-manager Certificate, in the form KIND/NAME[:KEY].
+type objectKeyRef struct {
+	kind string
+	name string
+	key  string
+}
+
+func (ref objectKeyRef) String() string {
+	return ref.kind + "/" + ref.name + ":" + ref.key
+}
+
+// parseObjectKeyRef parses s as KIND/NAME[:KEY], where KIND is one of kinds.
+// The key defaults to defaultKey when omitted.
+func parseObjectKeyRef(s, defaultKey string, kinds ...string) (objectKeyRef, error) {
+	kind, name, ok := strings.Cut(s, "/")
+	if !ok || name == "" {
+		return objectKeyRef{}, fmt.Errorf("invalid reference %q, expected %s/NAME[:KEY]", s, strings.Join(kinds, "|"))
+	}
+	ref := objectKeyRef{kind: normalizeObjectKind(kind), name: name, key: defaultKey}
+	if name, key, ok := strings.Cut(name, ":"); ok {
+		if key == "" {
+			return objectKeyRef{}, fmt.Errorf("invalid reference %q, missing key after the name", s)
+		}
+		ref.name, ref.key = name, key
+	}
+	for _, k := range kinds {
+		if ref.kind == k {
+			return ref, nil
+		}
+	}
+	return objectKeyRef{}, fmt.Errorf("unsupported kind %q in reference %q, expected %s", kind, s, strings.Join(kinds, ", "))
+}
+
+func normalizeObjectKind(kind string) string {
+	switch strings.ToLower(kind) {
+	case "secret", "secrets":
+		return "secret"
+	case "configmap", "configmaps", "cm":
+		return "configmap"
+	case "certificate", "certificates", "cert", "certs":
+		return "certificate"
+	}
+	return kind
+}
+
+// readObjectKey returns the value of the key referenced by ref in the
+// namespace of the resolver. The key of a certificate is read from the secret
+// that cert-manager stores the certificate in.
+func (r *podResolver) readObjectKey(ctx context.Context, ref objectKeyRef) ([]byte, error) {
+	switch ref.kind {
+	case "secret":
+		secret, err := r.client.CoreV1().Secrets(r.namespace).Get(ctx, ref.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get secret %s: %w", ref.name, err)
+		}
+		if value, ok := secret.Data[ref.key]; ok {
+			return value, nil
+		}
+
+	case "configmap":
+		configMap, err := r.client.CoreV1().ConfigMaps(r.namespace).Get(ctx, ref.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get configmap %s: %w", ref.name, err)
+		}
+		if value, ok := configMap.Data[ref.key]; ok {
+			return []byte(value), nil
+		}
+		if value, ok := configMap.BinaryData[ref.key]; ok {
+			return value, nil
+		}
+
+	case "certificate":
+		secretName, err := r.certificateSecretName(ctx, ref.name)
+		if err != nil {
+			return nil, err
+		}
+		return r.readObjectKey(ctx, objectKeyRef{kind: "secret", name: secretName, key: ref.key})
+	}
+
+	return nil, fmt.Errorf("key %s not found in %s %s", ref.key, ref.kind, ref.name)
+}
+
+// certificateSecretName returns the name of the secret that cert-manager
+// stores the certificate in.
+func (r *podResolver) certificateSecretName(ctx context.Context, name string) (string, error) {
+	obj, _, err := r.resolveObject(ctx, "certificates.cert-manager.io", name)
+	if err != nil {
+		return "", err
+	}
+	secretName, ok, err := unstructured.NestedString(obj.Object, "spec", "secretName")
+	if err != nil || !ok || secretName == "" {
+		return "", fmt.Errorf("certificate %s has no spec.secretName", name)
+	}
+	return secretName, nil
+}
+
+// createTemp creates the temporary files of writeTempFile, tests replace it to
+// exercise the error paths.
+var createTemp = os.CreateTemp
+
+// writeTempFile writes data to a new temporary file which only the current
+// user can read, and returns its path. The caller is responsible for removing
+// the file.
+func writeTempFile(pattern string, data []byte) (string, error) {
+	f, err := createTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := f.Chmod(0600); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// fetchCACert writes the CA bundle referenced by source to a temporary file and
+// returns its path. When source is empty, the kube-root-ca.crt ConfigMap of the
+// namespace is used if it exists, and an empty path is returned otherwise.
+func fetchCACert(ctx context.Context, resolver *podResolver, source string) (string, error) {
+	ref := objectKeyRef{kind: "configmap", name: rootCAConfigMap, key: "ca.crt"}
+	if source != "" {
+		var err error
+		if ref, err = parseObjectKeyRef(source, "ca.crt", "secret", "configmap", "certificate"); err != nil {
+			return "", usageError(err.Error())
+		}
+	}
+
+	caCert, err := resolver.readObjectKey(ctx, ref)
+	if err != nil {
+		if source == "" && (apierrors.IsNotFound(err) || apierrors.IsForbidden(err)) {
+			// The default CA is best effort, curl uses its own bundle.
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+
+	return writeTempFile("kubectl-curl-ca-*.crt", caCert)
+}
+
+// cacertSource returns the description of the CA certificate source for
+// verbose output.
+func cacertSource(source string) string {
+	if source == "" {
+		return "configmap/" + rootCAConfigMap
+	}
+	return source
+}
+
+// clientCertFiles are the temporary files holding the client certificate of a
+// kubernetes.io/tls secret.
+type clientCertFiles struct {
+	cert   string
+	key    string
+	caCert string // empty when the secret has no ca.crt
+}
+
+func (f clientCertFiles) remove() {
+	for _, path := range []string{f.cert, f.key, f.caCert} {
+		if path != "" {
+			os.Remove(path)
+		}
+	}
+}
+
+// fetchClientCert writes the tls.crt, tls.key, and optional ca.crt keys of the
+// kubernetes.io/tls secret referenced by source to temporary files.
+func fetchClientCert(ctx context.Context, resolver *podResolver, source string) (files clientCertFiles, err error) {
+	ref, err := parseObjectKeyRef(source, "", "secret")
+	if err != nil {
+		return files, usageError(err.Error())
+	}
+	if ref.key != "" {
+		return files, usageError(fmt.Sprintf("invalid reference %q, --cert-from takes a secret without key", source))
+	}
+
+	secret, err := resolver.client.CoreV1().Secrets(resolver.namespace).Get(ctx, ref.name, metav1.GetOptions{})
+	if err != nil {
+		return files, fmt.Errorf("failed to get secret %s: %w", ref.name, err)
+	}
+	for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+		if len(secret.Data[key]) == 0 {
+			return files, fmt.Errorf("key %s not found in secret %s", key, ref.name)
+		}
+	}
+
+	defer func() {
+		if err != nil {
+			files.remove()
+		}
+	}()
+	if files.cert, err = writeTempFile("kubectl-curl-cert-*.crt", secret.Data[corev1.TLSCertKey]); err != nil {
+		return files, err
+	}
+	if files.key, err = writeTempFile("kubectl-curl-key-*.key", secret.Data[corev1.TLSPrivateKeyKey]); err != nil {
+		return files, err
+	}
+	if caCert := secret.Data["ca.crt"]; len(caCert) != 0 {
+		if files.caCert, err = writeTempFile("kubectl-curl-ca-*.crt", caCert); err != nil {
+			return files, err
+		}
+	}
+	return files, nil
+}
+
+// dataArgs are the curl options whose values may reference a data source with
+// @secret/NAME/KEY or @configmap/NAME/KEY.
+var dataArgs = map[string]bool{
+	"--data":        true,
+	"--data-ascii":  true,
+	"--data-binary": true,
+}
+
+// parseDataSource parses the value of a data option. It returns false when the
+// value does not reference a secret or a ConfigMap.
+func parseDataSource(s string) (objectKeyRef, bool, error) {
+	if !strings.HasPrefix(s, "@secret/") && !strings.HasPrefix(s, "@configmap/") {
+		return objectKeyRef{}, false, nil
+	}
+	parts := strings.Split(s[1:], "/")
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return objectKeyRef{}, true, fmt.Errorf("invalid data source %q, expected @secret/NAME/KEY or @configmap/NAME/KEY", s)
+	}
+	return objectKeyRef{kind: parts[0], name: parts[1], key: parts[2]}, true, nil
+}
+
+// parseHeaderFrom parses the value of --header-from, in the form
+// KIND/NAME:KEY=HEADER-NAME.
+func parseHeaderFrom(s string) (objectKeyRef, string, error) {
+	i := strings.LastIndexByte(s, '=')
+	if i < 0 || i == len(s)-1 {
+		return objectKeyRef{}, "", fmt.Errorf("invalid header source %q, expected secret/NAME:KEY=HEADER-NAME", s)
+	}
+	ref, err := parseObjectKeyRef(s[:i], "", "secret", "configmap")
+	if err != nil {
+		return objectKeyRef{}, "", err
+	}
+	if ref.key == "" {
+		return objectKeyRef{}, "", fmt.Errorf("invalid header source %q, missing key after the name", s)
+	}
+	return ref, s[i+1:], nil
+}
+
+// verboseSecretsError is the error returned when the curl binary would print
+// the credentials of the config file written by secretArgs in its verbose
+// output.
+const verboseSecretsError = "-v/--verbose prints the headers sent by the curl binary, including the credentials of --header-from, --userinfo-from and --as-serviceaccount; use --executor native, which redacts them"
+
+// hasConfigSecrets returns whether secretArgs writes credentials to a curl
+// config file.
+func hasConfigSecrets() bool {
+	return len(headersFrom) != 0 || userinfoFrom != "" || serviceAccount != ""
+}
+
+// hasSecretData returns whether the data options of args are read from secrets
+// or ConfigMaps.
+func hasSecretData(args []string) bool {
+	for i, arg := range args {
+		if i > 0 && dataArgs[args[i-1]] {
+			if _, ok, _ := parseDataSource(arg); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// secretArgs rewrites the curl arguments to read the values of headers, user
+// credentials, bearer tokens, and request bodies from secrets and ConfigMaps.
+// The values are written to temporary files, headers and credentials in a curl
+// config file passed with --config, so they never appear in the command line
+// of curl. The names of the headers read from secrets and ConfigMaps are
+// returned so their values can be redacted from debug output. The returned
+// files must be removed by the caller, even when an error is returned.
+func secretArgs(ctx context.Context, resolver *podResolver, args, headersFrom []string, userinfoFrom, bearerToken string) (newArgs, headers, files []string, err error) {
+	newArgs = make([]string, 0, len(args)+2)
+	for i, arg := range args {
+		if i > 0 && dataArgs[args[i-1]] {
+			ref, ok, err := parseDataSource(arg)
+			if err != nil {
+				return nil, nil, files, usageError(err.Error())
+			}
+			if ok {
+				data, err := resolver.readObjectKey(ctx, ref)
+				if err != nil {
+					return nil, nil, files, err
+				}
+				path, err := writeTempFile("kubectl-curl-data-*", data)
+				if err != nil {
+					return nil, nil, files, err
+				}
+				files = append(files, path)
+				arg = "@" + path
+			}
+		}
+		newArgs = append(newArgs, arg)
+	}
+
+	config := new(strings.Builder)
+	for _, headerFrom := range headersFrom {
+		ref, header, err := parseHeaderFrom(headerFrom)
+		if err != nil {
+			return nil, nil, files, usageError(err.Error())
+		}
+		value, err := resolver.readObjectKey(ctx, ref)
+		if err != nil {
+			return nil, nil, files, err
+		}
+		fmt.Fprintf(config, "header = %s\n", curlConfigQuote(header+": "+strings.TrimSpace(string(value))))
+		headers = append(headers, header)
+	}
+
+	if userinfoFrom != "" {
+		ref, err := parseObjectKeyRef(userinfoFrom, "", "secret")
+		if err != nil {
+			return nil, nil, files, usageError(err.Error())
+		}
+		if ref.key != "" {
+			return nil, nil, files, usageError(fmt.Sprintf("invalid reference %q, --userinfo-from takes a secret without key", userinfoFrom))
+		}
+		var userinfo []string
+		for _, key := range []string{corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey} {
+			ref.key = key
+			value, err := resolver.readObjectKey(ctx, ref)
+			if err != nil {
+				return nil, nil, files, err
+			}
+			userinfo = append(userinfo, string(value))
+		}
+		fmt.Fprintf(config, "user = %s\n", curlConfigQuote(strings.Join(userinfo, ":")))
+	}
+
+	if bearerToken != "" {
+		fmt.Fprintf(config, "oauth2-bearer = %s\n", curlConfigQuote(bearerToken))
+	}
+
+	if config.Len() != 0 {
+		path, err := writeTempFile("kubectl-curl-config-*", []byte(config.String()))
+		if err != nil {
+			return nil, nil, files, err
+		}
+		files = append(files, path)
+		newArgs = append(newArgs, "--config", path)
+	}
+	return newArgs, headers, files, nil
+}
+
+// curlConfigQuote quotes s as a value of a curl config file.
+func curlConfigQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(s) + `"`
+}
+
+func removeTempFiles(files []string) {
+	for _, path := range files {
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestParseObjectKeyRef(t *testing.T) {
+	tests := []struct {
+		in  string
+		ref objectKeyRef
+	}{
+		{in: "secret/tls", ref: objectKeyRef{kind: "secret", name: "tls", key: "ca.crt"}},
+		{in: "secrets/tls:root.pem", ref: objectKeyRef{kind: "secret", name: "tls", key: "root.pem"}},
+		{in: "cm/trust-bundle:bundle.pem", ref: objectKeyRef{kind: "configmap", name: "trust-bundle", key: "bundle.pem"}},
+		{in: "certificate/web", ref: objectKeyRef{kind: "certificate", name: "web", key: "ca.crt"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			ref, err := parseObjectKeyRef(test.in, "ca.crt", "secret", "configmap", "certificate")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ref != test.ref {
+				t.Errorf("reference mismatch: want=%+v got=%+v", test.ref, ref)
+			}
+		})
+	}
+
+	for _, in := range []string{"", "secret", "secret/", "secret/tls:", "pod/tls"} {
+		if _, err := parseObjectKeyRef(in, "ca.crt", "secret", "configmap"); err == nil {
+			t.Errorf("expected an error parsing %q", in)
+		}
+	}
+}
+
+func TestParseHeaderFrom(t *testing.T) {
+	ref, header, err := parseHeaderFrom("secret/api-keys:billing=X-Api-Key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (objectKeyRef{kind: "secret", name: "api-keys", key: "billing"}); ref != want || header != "X-Api-Key" {
+		t.Errorf("header source mismatch: ref=%+v header=%q", ref, header)
+	}
+
+	for _, in := range []string{"secret/api-keys:billing", "secret/api-keys=X-Api-Key", "secret/api-keys:billing=", "pod/api-keys:billing=X-Api-Key"} {
+		if _, _, err := parseHeaderFrom(in); err == nil {
+			t.Errorf("expected an error parsing %q", in)
+		}
+	}
+}
+
+func TestParseDataSource(t *testing.T) {
+	tests := []struct {
+		in  string
+		ref objectKeyRef
+		ok  bool
+		err bool
+	}{
+		{in: "@secret/payloads/order.json", ref: objectKeyRef{kind: "secret", name: "payloads", key: "order.json"}, ok: true},
+		{in: "@configmap/payloads/order.json", ref: objectKeyRef{kind: "configmap", name: "payloads", key: "order.json"}, ok: true},
+		{in: "@body.json"},
+		{in: `{"secret":"value"}`},
+		{in: "@secret/payloads", ok: true, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			ref, ok, err := parseDataSource(test.in)
+			if (err != nil) != test.err {
+				t.Fatalf("error mismatch: %v", err)
+			}
+			if ok != test.ok || (!test.err && ref != test.ref) {
+				t.Errorf("data source mismatch: ok=%t ref=%+v", ok, ref)
+			}
+		})
+	}
+}
+
+func TestCurlConfigQuote(t *testing.T) {
+	if got, want := curlConfigQuote("Authorization: \"a\\b\"\n"), `"Authorization: \"a\\b\"\n"`; got != want {
+		t.Errorf("quoting mismatch: want=%s got=%s", want, got)
+	}
+}
+
+func TestSecretArgsBearerToken(t *testing.T) {
+	args, _, files, err := secretArgs(context.Background(), nil, []string{"http://localhost/"}, nil, "", "s3cr3t")
+	defer removeTempFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 3 || args[1] != "--config" || strings.Contains(strings.Join(args, " "), "s3cr3t") {
+		t.Fatalf("the token must only be passed in a config file: %q", args)
+	}
+	config, err := os.ReadFile(args[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(config), "oauth2-bearer = \"s3cr3t\"\n"; got != want {
+		t.Errorf("config mismatch: want=%q got=%q", want, got)
+	}
+}
+
+func TestSecretArgsVerboseHeaderFrom(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "api-key=%s", r.Header.Get("X-Api-Key"))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	r := newTestResolver([]runtime.Object{&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "ns"},
+		Data:       map[string][]byte{"key": []byte("s3cr3t")},
+	}})
+	args := []string{"--verbose", "--connect-to", "myservice.ns.svc::127.0.0.1:" + port, "http://myservice.ns.svc/"}
+	args, headers, files, err := secretArgs(context.Background(), r, args, []string{"secret/api:key=X-Api-Key"}, "", "")
+	defer removeTempFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 1 || headers[0] != "X-Api-Key" {
+		t.Fatalf("wrong secret headers: %q", headers)
+	}
+	redactHeaders(headers)
+	defer delete(sensitiveHeaders, "x-api-key")
+
+	n, err := parseNativeArgs(args, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	if err := n.run(context.Background(), stdout, stderr); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "api-key=s3cr3t" {
+		t.Errorf("the header was not sent: %q", stdout)
+	}
+	if strings.Contains(stderr.String(), "s3cr3t") || !strings.Contains(stderr.String(), "> X-Api-Key: <redacted>") {
+		t.Errorf("the header is not redacted from the verbose output:\n%s", stderr)
+	}
+	if got := prettyArgs([]string{"--header", "x-api-key: s3cr3t"}); strings.Contains(got, "s3cr3t") {
+		t.Errorf("the header is not redacted from the arguments: %s", got)
+	}
+}
+
+func TestFetchClientCert(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	tlsSecret := func(name string, data map[string]string) *corev1.Secret {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"}, Data: map[string][]byte{}}
+		for key, value := range data {
+			secret.Data[key] = []byte(value)
+		}
+		return secret
+	}
+	r := newTestResolver([]runtime.Object{
+		tlsSecret("client", map[string]string{"tls.crt": "CERT", "tls.key": "KEY", "ca.crt": "CA"}),
+		tlsSecret("noca", map[string]string{"tls.crt": "CERT", "tls.key": "KEY"}),
+		tlsSecret("nokey", map[string]string{"tls.crt": "CERT"}),
+	})
+	ctx := context.Background()
+
+	assertNoTempFiles := func(t *testing.T) {
+		t.Helper()
+		entries, err := os.ReadDir(tmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			t.Errorf("temporary file left behind: %s", entry.Name())
+		}
+	}
+
+	t.Run("certificate, key and CA", func(t *testing.T) {
+		files, err := fetchClientCert(ctx, r, "secret/client")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for path, want := range map[string]string{files.cert: "CERT", files.key: "KEY", files.caCert: "CA"} {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode := info.Mode().Perm(); mode != 0600 {
+				t.Errorf("%s: mode mismatch: want=0600 got=%o", path, mode)
+			}
+			if data, _ := os.ReadFile(path); string(data) != want {
+				t.Errorf("%s: content mismatch: want=%q got=%q", path, want, data)
+			}
+		}
+		files.remove()
+		assertNoTempFiles(t)
+	})
+
+	t.Run("secret without ca.crt", func(t *testing.T) {
+		files, err := fetchClientCert(ctx, r, "secret/noca")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer files.remove()
+		if files.cert == "" || files.key == "" || files.caCert != "" {
+			t.Errorf("wrong files: %+v", files)
+		}
+	})
+
+	t.Run("secret without tls.key", func(t *testing.T) {
+		_, err := fetchClientCert(ctx, r, "secret/nokey")
+		if want := "key tls.key not found in secret nokey"; err == nil || err.Error() != want {
+			t.Errorf("error mismatch: want=%q got=%v", want, err)
+		}
+		assertNoTempFiles(t)
+	})
+
+	t.Run("missing secret", func(t *testing.T) {
+		if _, err := fetchClientCert(ctx, r, "secret/missing"); err == nil {
+			t.Error("expected an error")
+		}
+		assertNoTempFiles(t)
+	})
+
+	t.Run("reference with a key", func(t *testing.T) {
+		if _, err := fetchClientCert(ctx, r, "secret/client:tls.crt"); err == nil {
+			t.Error("expected an error")
+		} else if _, ok := err.(usageError); !ok {
+			t.Errorf("expected a usage error, got %v", err)
+		}
+	})
+
+	// The files written before a failure are removed.
+	for n, name := range []string{"key", "CA"} {
+		t.Run("failure writing the "+name, func(t *testing.T) {
+			calls := 0
+			createTemp = func(dir, pattern string) (*os.File, error) {
+				if calls++; calls == n+2 {
+					return nil, fmt.Errorf("disk full")
+				}
+				return os.CreateTemp(dir, pattern)
+			}
+			defer func() { createTemp = os.CreateTemp }()
+
+			if _, err := fetchClientCert(ctx, r, "secret/client"); err == nil || err.Error() != "disk full" {
+				t.Errorf("error mismatch: want=%q got=%v", "disk full", err)
+			}
+			assertNoTempFiles(t)
+		})
+	}
+}
+
+func TestFetchCACert(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	certificate := testWorkload(certificateResource, "Certificate", "server", nil)
+	_ = unstructured.SetNestedField(certificate.Object, "server-tls", "spec", "secretName")
+	objects := []runtime.Object{
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "server-tls", Namespace: "ns"},
+			Data:       map[string][]byte{"ca.crt": []byte("CERT-MANAGER CA")},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "bundle", Namespace: "ns"},
+			Data:       map[string]string{"ca.crt": "BUNDLE CA"},
+		},
+	}
+	rootCA := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: rootCAConfigMap, Namespace: "ns"},
+		Data:       map[string]string{"ca.crt": "CLUSTER CA"},
+	}
+	ctx := context.Background()
+
+	forbidden := func(r *podResolver) {
+		r.client.(*fake.Clientset).PrependReactor("get", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			name := action.(k8stesting.GetAction).GetName()
+			return true, nil, apierrors.NewForbidden(corev1.Resource("configmaps"), name, errors.New("access denied"))
+		})
+	}
+
+	tests := []struct {
+		scenario string
+		rootCA   bool
+		setup    func(*podResolver)
+		source   string
+		cacert   string
+		err      string
+	}{
+		{
+			scenario: "kube-root-ca.crt",
+			rootCA:   true,
+			cacert:   "CLUSTER CA",
+		},
+		{
+			scenario: "missing kube-root-ca.crt",
+		},
+		{
+			scenario: "forbidden kube-root-ca.crt",
+			rootCA:   true,
+			setup:    forbidden,
+		},
+		{
+			scenario: "configmap",
+			source:   "configmap/bundle",
+			cacert:   "BUNDLE CA",
+		},
+		{
+			scenario: "certificate",
+			source:   "certificate/server",
+			cacert:   "CERT-MANAGER CA",
+		},
+		{
+			scenario: "missing configmap",
+			source:   "configmap/missing",
+			err:      `failed to read CA certificate: failed to get configmap missing: configmaps "missing" not found`,
+		},
+		{
+			scenario: "explicit kube-root-ca.crt which does not exist",
+			source:   "configmap/" + rootCAConfigMap,
+			err:      `failed to read CA certificate: failed to get configmap kube-root-ca.crt: configmaps "kube-root-ca.crt" not found`,
+		},
+		{
+			scenario: "forbidden configmap",
+			setup:    forbidden,
+			source:   "configmap/bundle",
+			err:      `failed to read CA certificate: failed to get configmap bundle: configmaps "bundle" is forbidden: access denied`,
+		},
+		{
+			scenario: "missing key",
+			source:   "configmap/bundle:tls.crt",
+			err:      "failed to read CA certificate: key tls.crt not found in configmap bundle",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			objects := objects
+			if test.rootCA {
+				objects = append([]runtime.Object{rootCA}, objects...)
+			}
+			r := newTestResolver(objects, certificate)
+			if test.setup != nil {
+				test.setup(r)
+			}
+
+			path, err := fetchCACert(ctx, r, test.source)
+			if path != "" {
+				defer os.Remove(path)
+			}
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("error mismatch: want=%q got=%v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.cacert == "" {
+				if path != "" {
+					t.Errorf("expected no CA certificate, got %s", path)
+				}
+				return
+			}
+			if data, _ := os.ReadFile(path); string(data) != test.cacert {
+				t.Errorf("CA certificate mismatch: want=%q got=%q", test.cacert, data)
+			}
+		})
+	}
+}
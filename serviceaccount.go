@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// minTokenTTL is the shortest lifetime that the TokenRequest API accepts.
+const minTokenTTL = 10 * time.Minute
+
+// createServiceAccountToken mints a short-lived token of the service account
+// with the TokenRequest API. When audience is empty, the token is valid for
+// the default audience of the API server.
+func createServiceAccountToken(ctx context.Context, resolver *podResolver, name, audience string, ttl time.Duration) (string, error) {
+	if ttl < minTokenTTL {
+		return "", usageError(fmt.Sprintf("--token-ttl must be at least %s", minTokenTTL))
+	}
+
+	expirationSeconds := int64(ttl / time.Second)
+	tokenRequest := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			ExpirationSeconds: &expirationSeconds,
+		},
+	}
+	if audience != "" {
+		tokenRequest.Spec.Audiences = []string{audience}
+	}
+
+	tokenRequest, err := resolver.client.CoreV1().ServiceAccounts(resolver.namespace).CreateToken(ctx, name, tokenRequest, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to create a token for serviceaccount %s: %w", name, err)
+	}
+	return tokenRequest.Status.Token, nil
+}